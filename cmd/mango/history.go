@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/example/mango/internal/history"
+	"github.com/example/mango/internal/quarantine"
+)
+
+// editQuarantine applies edit to the quarantine file.
+func editQuarantine(edit func(*quarantine.List)) error {
+	q, err := quarantine.Load(quarantine.DefaultPath)
+	if err != nil {
+		return err
+	}
+	edit(q)
+	return q.Save(quarantine.DefaultPath)
+}
+
+// flakyReason explains why a test was found flaky.
+func flakyReason(f history.FlakyTest) string {
+	var why []string
+	if f.Flips > 0 {
+		why = append(why, fmt.Sprintf("passed and failed on the same code in %d trees", f.Flips))
+	}
+	if f.Retried > 0 {
+		why = append(why, fmt.Sprintf("passed only on a retry in %d runs", f.Retried))
+	}
+	return strings.Join(why, ", ")
+}
+
+// historyDir holds the run history. eval makes it absolute since it works
+// inside temporary worktrees.
+var historyDir = history.DefaultDir
+
+// shadowDue decides whether this run also runs the unselected tests: by
+// chance at --shadow-rate, or when the history has no shadow run within
+// --shadow-interval.
+func shadowDue(store *history.Store) bool {
+	if shadowRate > 0 && rand.Float64() < shadowRate {
+		return true
+	}
+	if shadowInterval <= 0 || store == nil {
+		return false
+	}
+	runs, err := store.List()
+	if err != nil {
+		log.Printf("history: %v", err)
+		return false
+	}
+	for _, r := range runs {
+		if r.Shadow {
+			return time.Since(r.Time) >= shadowInterval
+		}
+	}
+	return true
+}
+
+// modelName renders the provider and model, or the strategy, that made a
+// run's selection.
+func modelName(r history.Run) string {
+	if r.Model == "" {
+		return r.Provider
+	}
+	return r.Provider + "/" + r.Model
+}
+
+// commits renders the resolved diff range with abbreviated SHAs.
+func commits(r history.Run) string {
+	head := "working tree"
+	if r.Head != "" {
+		head = short(r.Head)
+	}
+	return short(r.Base) + ".." + head
+}
+
+func short(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
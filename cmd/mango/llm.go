@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/mango/internal/llm"
+	"github.com/example/mango/internal/redact"
+)
+
+// tokenEnv names the environment variable consulted for a provider's token
+// when --llm-token does not apply to it.
+var tokenEnv = map[llm.Provider]string{
+	llm.ProviderOpenAI:    "OPENAI_API_KEY",
+	llm.ProviderAnthropic: "ANTHROPIC_API_KEY",
+	llm.ProviderGemini:    "GEMINI_API_KEY",
+}
+
+// llmOptions collects the model flags for p. The model, endpoint and
+// sampling flags describe the --provider model only; other providers in a
+// fallback chain use their defaults.
+func llmOptions(p llm.Provider) llm.Options {
+	opts := llm.Options{
+		ContextTokens: contextTokens[string(p)],
+		Retry:         &retryPolicy,
+		Cache:         responseCache(),
+		HTTPClient:    httpClient,
+		Redact:        redactor.Redact,
+		Meter:         meter,
+	}
+	switch {
+	case showPrompt && promptLog != nil:
+		opts.ShowPrompt = io.MultiWriter(os.Stderr, promptLog)
+	case showPrompt:
+		opts.ShowPrompt = os.Stderr
+	case promptLog != nil:
+		opts.ShowPrompt = promptLog
+	}
+	if p != llm.Provider(provider) {
+		return opts
+	}
+	opts.Model = model
+	opts.BaseURL = baseURL
+	opts.MaxTokens = maxTokens
+	if temperature >= 0 {
+		opts.Temperature = &temperature
+	}
+	return opts
+}
+
+// promptLog collects the prompts sent by clients created after
+// capturePrompts.
+var promptLog *strings.Builder
+
+// capturePrompts keeps every prompt sent from now on and returns a function
+// reading them back.
+func capturePrompts() func() string {
+	promptLog = &strings.Builder{}
+	return promptLog.String
+}
+
+// redactor cleans every prompt before it is sent.
+var redactor *redact.Redactor
+
+func setupRedaction() error {
+	var err error
+	redactor, err = redact.New(redactPatterns, denyPaths, redactEntropy)
+	return err
+}
+
+// meter accounts for the tokens and cost of every LLM call in this process.
+var meter *llm.Meter
+
+func setupMeter() error {
+	prices, err := llm.LoadPrices(pricesFile)
+	if err != nil {
+		return err
+	}
+	meter = llm.NewMeter(prices, maxCost)
+	return nil
+}
+
+// httpClient carries LLM traffic. It records or replays it when --record
+// or --replay is set, and is nil otherwise.
+var httpClient *http.Client
+
+// setupTraffic prepares httpClient for --record and --replay.
+func setupTraffic() error {
+	var transport http.RoundTripper
+	var err error
+	switch {
+	case recordDir != "":
+		// eval changes directory while recording.
+		if recordDir, err = filepath.Abs(recordDir); err != nil {
+			return err
+		}
+		transport, err = llm.NewRecorder(recordDir, http.DefaultTransport)
+	case replayDir != "":
+		transport, err = llm.NewReplayer(replayDir)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	httpClient = &http.Client{Transport: transport}
+	return nil
+}
+
+// responseCache returns the on-disk response cache, or nil with --no-cache.
+// Recording and replaying bypass the cache so every call reaches the
+// transport.
+func responseCache() *llm.Cache {
+	if noCache || recordDir != "" || replayDir != "" {
+		return nil
+	}
+	return llm.NewCache(cacheDir, cacheTTL, cacheMaxSize)
+}
+
+// cacheDir is the response cache directory. eval makes it absolute since it
+// works inside temporary worktrees.
+var cacheDir = llm.DefaultCacheDir
+
+// tokenFor returns --llm-token for the --provider provider and the
+// provider's own environment variable otherwise. Replays need no real token.
+func tokenFor(p llm.Provider) string {
+	if p == llm.Provider(provider) && llmToken != "" {
+		return llmToken
+	}
+	if token := os.Getenv(tokenEnv[p]); token != "" || replayDir == "" {
+		return token
+	}
+	return "replay"
+}
+
+// newClientFor returns a client for p configured from the flags.
+func newClientFor(p llm.Provider) (llm.Client, error) {
+	return llm.New(p, tokenFor(p), llmOptions(p))
+}
+
+// newClient returns the --provider client shared by every command.
+func newClient() (llm.Client, error) {
+	return newClientFor(llm.Provider(provider))
+}
+
+func envFloat(key string, def float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func envList(key string) []string {
+	if v := os.Getenv(key); v != "" {
+		return strings.Split(v, ",")
+	}
+	return nil
+}
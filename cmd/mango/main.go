@@ -2,15 +2,34 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
 	"github.com/spf13/cobra"
 
 	"github.com/example/mango/internal/advisor"
 	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/eval"
+	"github.com/example/mango/internal/executor"
+	"github.com/example/mango/internal/fakellm"
 	"github.com/example/mango/internal/generator"
+	"github.com/example/mango/internal/history"
+	"github.com/example/mango/internal/llm"
 	"github.com/example/mango/internal/llmselector"
+	"github.com/example/mango/internal/ml"
 	"github.com/example/mango/internal/orchestrator"
 	"github.com/example/mango/internal/predictor"
+	"github.com/example/mango/internal/prompt"
+	"github.com/example/mango/internal/quarantine"
 	"github.com/example/mango/internal/query"
+	"github.com/example/mango/internal/redact"
 	"github.com/example/mango/internal/testmeta"
 )
 
@@ -21,11 +40,100 @@ var (
 	provider  string
 	planDesc  string
 	question  string
+
+	contextTokens map[string]int
+	concurrency   int
+	hierarchical  bool
+	minConfidence float64
+	maxSelections int
+
+	model       string
+	baseURL     string
+	temperature float64
+	maxTokens   int
+
+	retryPolicy = llm.DefaultRetryPolicy
+
+	fallback string
+	strict   bool
+
+	safetyNet bool
+	alwaysRun []string
+
+	ensemble []string
+	samples  int
+	vote     string
+	quorum   int
+
+	noCache      bool
+	cacheTTL     time.Duration
+	cacheMaxSize int64
+
+	recordDir string
+	replayDir string
+
+	maxCost    float64
+	pricesFile string
+
+	redactPatterns []string
+	denyPaths      []string
+	redactEntropy  float64
+	showPrompt     bool
+
+	noHistory     bool
+	historyLimit  int
+	historyFormat string
+
+	shadowRate     float64
+	shadowInterval time.Duration
+
+	timeBudget time.Duration
+	maxTests   int
+	failFast   bool
+
+	shard    string
+	planFile string
+	planOut  string
+
+	historyThreshold float64
+	historyTop       int
+	historyWindow    int
+	historyHalfLife  time.Duration
+
+	cochangeSupport    int
+	cochangeConfidence float64
+	cochangeCommits    int
+	cochangeMaxFiles   int
+
+	modelPath   string
+	mlThreshold float64
+	mlTop       int
+
+	retries          int
+	quarantineReason string
+	quarantineAdd    bool
+
+	evalCommits    string
+	evalSelectors  []string
+	evalShowOutput bool
+
+	fakeListen string
+	fakeRules  string
+	fakePolicy string
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "mango",
 	Short: "Smart test runner",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := setupRedaction(); err != nil {
+			return err
+		}
+		if err := setupMeter(); err != nil {
+			return err
+		}
+		return setupTraffic()
+	},
 }
 
 func main() {
@@ -35,25 +143,141 @@ func main() {
 func init() {
 	rootCmd.PersistentFlags().StringVar(&diffRange, "diff", "HEAD~1", "git diff range")
 	rootCmd.PersistentFlags().StringVar(&mode, "mode", "auto", "execution mode: auto, go, ginkgo")
-	rootCmd.PersistentFlags().StringVar(&llmToken, "llm-token", "", "LLM API token")
-	rootCmd.PersistentFlags().StringVar(&provider, "provider", string(llmselector.ProviderOpenAI), "LLM provider: openai, anthropic, gemini")
+	rootCmd.PersistentFlags().StringVar(&llmToken, "llm-token", os.Getenv("LLM_TOKEN"), "LLM API token (env LLM_TOKEN)")
+	rootCmd.PersistentFlags().StringVar(&provider, "provider", string(llm.ProviderOpenAI), "LLM provider: openai, anthropic, gemini")
 	rootCmd.PersistentFlags().StringVar(&planDesc, "plan", "", "planned change description")
 	rootCmd.PersistentFlags().StringVar(&question, "question", "", "query question")
+	rootCmd.PersistentFlags().StringVar(&model, "model", os.Getenv("MANGO_MODEL"), "model name, defaults to the provider's default (env MANGO_MODEL)")
+	rootCmd.PersistentFlags().StringVar(&baseURL, "base-url", os.Getenv("MANGO_BASE_URL"), "provider API base URL, e.g. an OpenAI-compatible local server (env MANGO_BASE_URL)")
+	rootCmd.PersistentFlags().Float64Var(&temperature, "temperature", envFloat("MANGO_TEMPERATURE", -1), "sampling temperature, negative uses the provider default (env MANGO_TEMPERATURE)")
+	rootCmd.PersistentFlags().IntVar(&maxTokens, "max-tokens", envInt("MANGO_MAX_TOKENS", 0), "completion token budget, 0 uses the provider default (env MANGO_MAX_TOKENS)")
+	rootCmd.PersistentFlags().IntVar(&retryPolicy.MaxRetries, "llm-retries", envInt("MANGO_LLM_RETRIES", retryPolicy.MaxRetries), "retries for rate-limited, overloaded or failed LLM calls (env MANGO_LLM_RETRIES)")
+	rootCmd.PersistentFlags().DurationVar(&retryPolicy.BaseDelay, "llm-backoff", retryPolicy.BaseDelay, "initial retry backoff, doubled on every attempt")
+	rootCmd.PersistentFlags().DurationVar(&retryPolicy.MaxDelay, "llm-max-backoff", retryPolicy.MaxDelay, "upper bound for the retry backoff")
+	rootCmd.PersistentFlags().DurationVar(&retryPolicy.Timeout, "llm-timeout", envDuration("MANGO_LLM_TIMEOUT", retryPolicy.Timeout), "timeout for each LLM call attempt (env MANGO_LLM_TIMEOUT)")
+	rootCmd.PersistentFlags().StringVar(&fallback, "fallback", os.Getenv("MANGO_FALLBACK"), "selection strategies to try in order, e.g. anthropic,openai,static,all (default: --provider then all; env MANGO_FALLBACK)")
+	rootCmd.PersistentFlags().BoolVar(&strict, "strict", false, "fail when the first selection strategy fails instead of falling back")
+	rootCmd.PersistentFlags().BoolVar(&safetyNet, "safety-net", true, "always run tests in changed packages and edited test files alongside the model's choice")
+	rootCmd.PersistentFlags().StringSliceVar(&alwaysRun, "always-run", envList("MANGO_ALWAYS_RUN"), "tests to run on every change, by ID, name or glob, e.g. internal/auth:*,TestSmoke (env MANGO_ALWAYS_RUN)")
+	rootCmd.PersistentFlags().StringSliceVar(&ensemble, "ensemble", envList("MANGO_ENSEMBLE"), "providers to query in parallel and combine, e.g. openai,anthropic (env MANGO_ENSEMBLE)")
+	rootCmd.PersistentFlags().IntVar(&samples, "samples", 1, "times each ensemble provider is asked, at a nonzero temperature when above 1")
+	rootCmd.PersistentFlags().StringVar(&vote, "vote", llmselector.VoteMajority, "how ensemble answers are combined: union, intersection, majority")
+	rootCmd.PersistentFlags().IntVar(&quorum, "quorum", 0, "votes a test needs under majority voting (default more than half)")
+	rootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "always call the LLM instead of reusing responses cached in "+llm.DefaultCacheDir)
+	rootCmd.PersistentFlags().DurationVar(&cacheTTL, "cache-ttl", envDuration("MANGO_CACHE_TTL", 7*24*time.Hour), "expire cached responses after this long, 0 keeps them (env MANGO_CACHE_TTL)")
+	rootCmd.PersistentFlags().Int64Var(&cacheMaxSize, "cache-max-size", 256<<20, "evict the least recently used cached responses beyond this many bytes")
+	rootCmd.PersistentFlags().StringVar(&recordDir, "record", "", "write every LLM request and response to this directory as fixtures")
+	rootCmd.PersistentFlags().StringVar(&replayDir, "replay", "", "answer LLM requests from fixtures recorded with --record, without network access")
+	rootCmd.MarkFlagsMutuallyExclusive("record", "replay")
+	rootCmd.PersistentFlags().Float64Var(&maxCost, "max-cost", envFloat("MANGO_MAX_COST", 0), "refuse LLM calls that could push this run past this many US dollars, 0 means no limit (env MANGO_MAX_COST)")
+	rootCmd.PersistentFlags().StringVar(&pricesFile, "prices", ".mango/prices.yaml", "YAML price table in dollars per million tokens, e.g. gpt-4o: {input: 5, output: 15}")
+	rootCmd.PersistentFlags().StringArrayVar(&redactPatterns, "redact", nil, "regular expression to mask in prompts, repeatable")
+	rootCmd.PersistentFlags().StringSliceVar(&denyPaths, "deny-path", envList("MANGO_DENY_PATHS"), "never send lines mentioning paths matching these globs, e.g. internal/secrets/** (env MANGO_DENY_PATHS)")
+	rootCmd.PersistentFlags().Float64Var(&redactEntropy, "redact-entropy", redact.DefaultMinEntropy, "mask tokens with at least this entropy in bits per character, 0 disables")
+	rootCmd.PersistentFlags().BoolVar(&showPrompt, "show-prompt", false, "print every prompt to stderr exactly as it is sent")
+	rootCmd.PersistentFlags().StringToIntVar(&contextTokens, "context-tokens", nil, "model context window per provider, e.g. openai=128000,anthropic=200000")
+	rootCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 0, "maximum concurrent LLM requests during selection (0 uses the provider default)")
+	rootCmd.PersistentFlags().Float64Var(&minConfidence, "min-confidence", 0, "drop selected tests the model is less confident about (0-1)")
+	rootCmd.PersistentFlags().IntVar(&maxSelections, "max-selections", 0, "reject model answers selecting more tests than this as suspicious (0 means no cap)")
+	rootCmd.PersistentFlags().BoolVar(&noHistory, "no-history", false, "do not record runs in "+history.DefaultDir)
+	rootCmd.PersistentFlags().Float64Var(&historyThreshold, "history-threshold", 0.2, "lowest score (0-1) the history strategy selects a test at")
+	rootCmd.PersistentFlags().IntVar(&historyTop, "history-top", 0, "most tests the history strategy selects, 0 means no cap")
+	rootCmd.PersistentFlags().IntVar(&historyWindow, "history-window", 200, "recorded runs the history strategy considers, newest first, 0 uses all")
+	rootCmd.PersistentFlags().DurationVar(&historyHalfLife, "history-half-life", 7*24*time.Hour, "age at which a recorded run counts half as much for the history strategy")
+	rootCmd.PersistentFlags().IntVar(&cochangeSupport, "cochange-support", 2, "commits a source and test file must change together in for the cochange strategy")
+	rootCmd.PersistentFlags().Float64Var(&cochangeConfidence, "cochange-confidence", 0.3, "share (0-1) of a source file's commits that must also change the test file for the cochange strategy")
+	rootCmd.PersistentFlags().IntVar(&cochangeCommits, "cochange-commits", 1000, "recent commits the cochange strategy mines, 0 mines all")
+	rootCmd.PersistentFlags().IntVar(&cochangeMaxFiles, "cochange-max-files", 50, "ignore commits touching more files than this when mining co-changes, 0 keeps all")
+	rootCmd.PersistentFlags().StringVar(&modelPath, "model-file", ml.DefaultPath, "model trained by mango train for the ml strategy")
+	rootCmd.PersistentFlags().Float64Var(&mlThreshold, "ml-threshold", 0.5, "lowest predicted failure probability (0-1) the ml strategy selects a test at")
+	rootCmd.PersistentFlags().IntVar(&mlTop, "ml-top", 0, "most tests the ml strategy selects, 0 means no cap")
+	rootCmd.PersistentFlags().IntVar(&retries, "retries", envInt("MANGO_RETRIES", 0), "rerun failed tests this many times before counting them as failures (env MANGO_RETRIES)")
+	runCmd.Flags().Float64Var(&shadowRate, "shadow-rate", envFloat("MANGO_SHADOW_RATE", 0), "share of runs that also run the unselected tests to find escaped failures, e.g. 0.1 (env MANGO_SHADOW_RATE)")
+	runCmd.Flags().DurationVar(&shadowInterval, "shadow-interval", envDuration("MANGO_SHADOW_INTERVAL", 0), "shadow run when none was recorded within this long, e.g. 24h (env MANGO_SHADOW_INTERVAL)")
+	runCmd.Flags().DurationVar(&timeBudget, "time-budget", envDuration("MANGO_TIME_BUDGET", 0), "run only the selected tests most likely to fail that fit this long by recorded durations, e.g. 5m (env MANGO_TIME_BUDGET)")
+	runCmd.Flags().IntVar(&maxTests, "max-tests", 0, "run at most this many selected tests, the most likely to fail, 0 means no cap")
+	runCmd.Flags().BoolVar(&failFast, "fail-fast", false, "stop at the first failing test")
+	for _, cmd := range []*cobra.Command{runCmd, dryRunCmd} {
+		cmd.Flags().StringVar(&shard, "shard", os.Getenv("MANGO_SHARD"), "run only shard i of n of the --plan-file tests, balanced by planned durations, e.g. 3/8 (env MANGO_SHARD)")
+	}
+	for _, cmd := range []*cobra.Command{runCmd, dryRunCmd} {
+		cmd.Flags().StringVar(&planFile, "plan-file", "", "take the tests of a plan written by mango plan instead of selecting them")
+	}
+	planCmd.Flags().StringVar(&planOut, "out", "plan.json", "file to write the plan to")
+	rootCmd.PersistentFlags().BoolVar(&hierarchical, "hierarchical", false, "select affected packages first, then tests within each package")
 
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(dryRunCmd)
+	rootCmd.AddCommand(planCmd)
 	rootCmd.AddCommand(generateCmd)
 	rootCmd.AddCommand(predictCmd)
 	rootCmd.AddCommand(adviceCmd)
 	rootCmd.AddCommand(queryCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(evalCmd)
+	rootCmd.AddCommand(quarantineCmd)
+	rootCmd.AddCommand(trainCmd)
+	rootCmd.AddCommand(fakeLLMCmd)
+	fakeLLMCmd.Flags().StringVar(&fakeListen, "listen", ":8089", "address to listen on")
+	fakeLLMCmd.Flags().StringVar(&fakeRules, "rules", "", "YAML rules file mapping prompt regexes to answers")
+	fakeLLMCmd.Flags().StringVar(&fakePolicy, "policy", fakellm.PolicyAll, "answer for prompts no rule matches: all, none, error")
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+	historyCmd.AddCommand(historyListCmd)
+	historyCmd.AddCommand(historyShowCmd)
+	historyCmd.AddCommand(historyExportCmd)
+	historyCmd.AddCommand(historyEscapesCmd)
+	quarantineCmd.AddCommand(quarantineAddCmd)
+	quarantineCmd.AddCommand(quarantineRemoveCmd)
+	quarantineCmd.AddCommand(quarantineListCmd)
+	quarantineCmd.AddCommand(quarantineDetectCmd)
+	quarantineAddCmd.Flags().StringVar(&quarantineReason, "reason", "", "why the tests are quarantined")
+	quarantineDetectCmd.Flags().BoolVar(&quarantineAdd, "add", false, "quarantine the flaky tests found")
+	historyListCmd.Flags().IntVar(&historyLimit, "limit", 20, "show at most this many runs, 0 shows all")
+	evalCmd.Flags().StringVar(&evalCommits, "commits", "", "commit range to replay, e.g. origin/main~200..origin/main")
+	evalCmd.Flags().StringSliceVar(&evalSelectors, "selectors", nil, "strategies to compare, e.g. openai/gpt-4o,anthropic,static (default: the configured selection)")
+	evalCmd.Flags().BoolVar(&evalShowOutput, "show-output", false, "print the output of the test suites")
+	evalCmd.MarkFlagRequired("commits")
+	historyExportCmd.Flags().StringVar(&historyFormat, "format", history.FormatJSON, "export format: json, csv (one row per test result)")
 }
 
 var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Run selected tests",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		sel := llmselector.NewSelector(llmselector.Provider(provider), llmToken)
-		orch := orchestrator.Orchestrator{Selector: sel, Mode: mode}
+		q, err := quarantine.Load(quarantine.DefaultPath)
+		if err != nil {
+			return err
+		}
+		orch := orchestrator.Orchestrator{
+			Mode: mode, Retries: retries, Quarantine: q,
+			TimeBudget: timeBudget, MaxTests: maxTests, FailFast: failFast,
+		}
+		if orch.Shard, orch.Shards, err = parseShard(); err != nil {
+			return err
+		}
+		if !noHistory {
+			orch.History = history.Open(historyDir)
+		}
+		if planFile != "" {
+			// A plan needs no LLM access: the selection was made when
+			// planning.
+			if orch.Plan, err = loadPlan(planFile); err != nil {
+				return err
+			}
+			orch.Shadow = shadowDue(orch.History)
+			return orch.Run(cmd.Context(), orch.Plan.Range)
+		}
+		if orch.Shadow = shadowDue(orch.History); orch.Shadow {
+			orch.Prompts = capturePrompts()
+		}
+		sel, err := newSelector()
+		if err != nil {
+			return err
+		}
+		orch.Selector = sel
+		defer meter.WriteSummary(os.Stdout)
 		return orch.Run(cmd.Context(), diffRange)
 	},
 }
@@ -62,17 +286,59 @@ var dryRunCmd = &cobra.Command{
 	Use:   "dry-run",
 	Short: "Preview selected tests",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		sel := llmselector.NewSelector(llmselector.Provider(provider), llmToken)
-		orch := orchestrator.Orchestrator{Selector: sel, Mode: mode, DryRun: true}
+		orch := orchestrator.Orchestrator{Mode: mode, DryRun: true}
+		var err error
+		if orch.Shard, orch.Shards, err = parseShard(); err != nil {
+			return err
+		}
+		if planFile != "" {
+			if orch.Plan, err = loadPlan(planFile); err != nil {
+				return err
+			}
+			return orch.Run(cmd.Context(), orch.Plan.Range)
+		}
+		if orch.Selector, err = newSelector(); err != nil {
+			return err
+		}
+		defer meter.WriteSummary(os.Stdout)
 		return orch.Run(cmd.Context(), diffRange)
 	},
 }
 
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Select tests and write them to a plan for mango run --plan-file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sel, err := newSelector()
+		if err != nil {
+			return err
+		}
+		defer meter.WriteSummary(os.Stdout)
+		orch := orchestrator.Orchestrator{Selector: sel, Mode: mode}
+		if !noHistory {
+			orch.History = history.Open(historyDir)
+		}
+		p, err := orch.NewPlan(cmd.Context(), diffRange)
+		if err != nil {
+			return err
+		}
+		if err := p.Save(planOut); err != nil {
+			return err
+		}
+		fmt.Printf("Planned %d tests in %d packages to %s\n", p.Tests(), len(p.Packages), planOut)
+		return nil
+	},
+}
+
 var generateCmd = &cobra.Command{
 	Use:   "generate-tests",
 	Short: "Generate new test scenarios",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		sel := generator.New(generator.NewOpenAIClient(llmToken))
+		client, err := newClient()
+		if err != nil {
+			return err
+		}
+		sel := generator.New(client)
 		changes, err := diff.AnalyzeDiff(diffRange)
 		if err != nil {
 			return err
@@ -100,7 +366,11 @@ var predictCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		p := predictor.New(predictor.NewOpenAIClient(llmToken))
+		client, err := newClient()
+		if err != nil {
+			return err
+		}
+		p := predictor.New(client)
 		names, err := p.Predict(cmd.Context(), planDesc, tests)
 		if err != nil {
 			return err
@@ -116,7 +386,11 @@ var adviceCmd = &cobra.Command{
 	Use:   "advise",
 	Short: "Get code quality advice",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		a := advisor.New(advisor.NewOpenAIClient(llmToken))
+		client, err := newClient()
+		if err != nil {
+			return err
+		}
+		a := advisor.New(client)
 		msg, err := a.Advise(cmd.Context())
 		if err != nil {
 			return err
@@ -134,7 +408,11 @@ var queryCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		q := query.New(query.NewOpenAIClient(llmToken))
+		client, err := newClient()
+		if err != nil {
+			return err
+		}
+		q := query.New(client)
 		msg, err := q.Ask(cmd.Context(), question, tests)
 		if err != nil {
 			return err
@@ -143,3 +421,353 @@ var queryCmd = &cobra.Command{
 		return nil
 	},
 }
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect or clear the LLM response cache",
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the size of the LLM response cache",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stats, err := llm.NewCache(llm.DefaultCacheDir, cacheTTL, cacheMaxSize).Stats()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Entries: %d\n", stats.Entries)
+		fmt.Printf("Size:    %d bytes\n", stats.Bytes)
+		if stats.Entries > 0 {
+			fmt.Printf("Oldest:  %s\n", stats.Oldest.Format(time.RFC3339))
+			fmt.Printf("Newest:  %s\n", stats.Newest.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove every cached LLM response",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return llm.NewCache(llm.DefaultCacheDir, cacheTTL, cacheMaxSize).Clear()
+	},
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Inspect or export recorded runs",
+}
+
+var historyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recorded runs, newest first",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		runs, err := history.Open(historyDir).List()
+		if err != nil {
+			return err
+		}
+		if historyLimit > 0 && len(runs) > historyLimit {
+			runs = runs[:historyLimit]
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTIME\tRANGE\tSELECTED\tFAILED\tESCAPES\tDURATION\tMODEL")
+		for _, r := range runs {
+			escapes := "-"
+			if r.Shadow {
+				escapes = fmt.Sprint(len(r.Escapes))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%d\t%s\t%s\t%s\n", r.ID, r.Time.Local().Format(time.DateTime), r.Range,
+				len(r.Selected), len(r.Candidates), len(r.Failed()), escapes, r.Duration, modelName(r))
+		}
+		return w.Flush()
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show <run-id>",
+	Short: "Show a recorded run",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		r, err := history.Open(historyDir).Get(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Run:      %s\n", r.ID)
+		fmt.Printf("Time:     %s\n", r.Time.Local().Format(time.DateTime))
+		fmt.Printf("Range:    %s (%s)\n", r.Range, commits(r))
+		fmt.Printf("Model:    %s\n", modelName(r))
+		fmt.Printf("Duration: %s\n", r.Duration)
+		if r.Error != "" {
+			fmt.Printf("Error:    %s\n", r.Error)
+		}
+		fmt.Println("Changes:")
+		for _, c := range r.Changes {
+			if len(c.Functions) > 0 {
+				fmt.Printf("- %s: %s\n", c.File, strings.Join(c.Functions, ", "))
+				continue
+			}
+			fmt.Printf("- %s\n", c.File)
+		}
+		fmt.Printf("Selected %d of %d tests:\n", len(r.Selected), len(r.Candidates))
+		for _, s := range r.Selected {
+			votes := ""
+			if s.Voters > 1 {
+				votes = fmt.Sprintf(" [votes %d/%d]", s.Votes, s.Voters)
+			}
+			fmt.Printf("- %s [%.2f] %s (%s)%s\n", s.ID, s.Confidence, s.Reason, s.Source, votes)
+		}
+		fmt.Println("Results:")
+		for _, res := range r.Results {
+			fmt.Printf("- %s %s (%s)\n", res.Outcome, res.ID, res.Duration)
+		}
+		if len(r.Escapes) == 0 {
+			return nil
+		}
+		fmt.Println("Escaped failures, not selected:")
+		for _, id := range r.Escapes {
+			fmt.Printf("- %s\n", id)
+		}
+		if r.Prompt != "" {
+			fmt.Printf("Prompt:\n%s", r.Prompt)
+		}
+		fmt.Printf("Diff:\n%s", r.Diff)
+		return nil
+	},
+}
+
+var historyEscapesCmd = &cobra.Command{
+	Use:   "escapes",
+	Short: "Summarise failures that shadow runs found outside the selection",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		runs, err := history.Open(historyDir).List()
+		if err != nil {
+			return err
+		}
+		stats := history.Escapes(runs)
+		fmt.Printf("Shadow runs:       %d\n", stats.ShadowRuns)
+		fmt.Printf("Runs with escapes: %d\n", stats.RunsWithEscapes)
+		fmt.Printf("Escaped failures:  %d of %d (%.1f%%)\n", stats.Escapes, stats.Failures, stats.Rate()*100)
+		for _, r := range runs {
+			if len(r.Escapes) > 0 {
+				fmt.Printf("- %s %s: %s\n", r.ID, modelName(r), strings.Join(r.Escapes, ", "))
+			}
+		}
+		return nil
+	},
+}
+
+var historyExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write every recorded run to stdout",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		runs, err := history.Open(historyDir).List()
+		if err != nil {
+			return err
+		}
+		return history.Export(os.Stdout, runs, historyFormat)
+	},
+}
+
+var evalCmd = &cobra.Command{
+	Use:   "eval",
+	Short: "Measure selection quality on historical commits",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Commits are evaluated inside temporary worktrees, so state
+		// directories must not depend on the working directory.
+		var err error
+		if cacheDir, err = filepath.Abs(cacheDir); err != nil {
+			return err
+		}
+		if prompt.Dir, err = filepath.Abs(prompt.Dir); err != nil {
+			return err
+		}
+		if historyDir, err = filepath.Abs(historyDir); err != nil {
+			return err
+		}
+		if modelPath, err = filepath.Abs(modelPath); err != nil {
+			return err
+		}
+		e := eval.Evaluator{Mode: mode, Retries: retries}
+		if !noHistory {
+			e.History = history.Open(historyDir)
+		}
+		if !evalShowOutput {
+			executor.Output = io.Discard
+		}
+
+		if len(evalSelectors) == 0 {
+			sel, err := newSelector()
+			if err != nil {
+				return err
+			}
+			e.Strategies = append(e.Strategies, llmselector.Strategy{Name: strategyLabel(fallbackChain()[0]), Selector: sel})
+		}
+		for _, name := range evalSelectors {
+			sel, err := buildSelector([]string{name})
+			if err != nil {
+				return err
+			}
+			e.Strategies = append(e.Strategies, llmselector.Strategy{Name: strategyLabel(name), Selector: sel})
+		}
+
+		summary, err := e.Evaluate(cmd.Context(), evalCommits)
+		if err != nil {
+			return err
+		}
+		defer meter.WriteSummary(os.Stdout)
+		return summary.Write(os.Stdout)
+	},
+}
+
+var quarantineCmd = &cobra.Command{
+	Use:   "quarantine",
+	Short: "Manage tests whose failures do not fail a run",
+}
+
+var quarantineAddCmd = &cobra.Command{
+	Use:   "add <test-id>...",
+	Short: "Quarantine tests",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return editQuarantine(func(q *quarantine.List) {
+			for _, id := range args {
+				if !q.Add(id, quarantineReason, time.Now()) {
+					fmt.Printf("%s is already quarantined\n", id)
+				}
+			}
+		})
+	},
+}
+
+var quarantineRemoveCmd = &cobra.Command{
+	Use:   "remove <test-id>...",
+	Short: "Release tests from quarantine",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return editQuarantine(func(q *quarantine.List) {
+			for _, id := range args {
+				if !q.Remove(id) {
+					fmt.Printf("%s is not quarantined\n", id)
+				}
+			}
+		})
+	},
+}
+
+var quarantineListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List quarantined tests",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		q, err := quarantine.Load(quarantine.DefaultPath)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TEST\tADDED\tREASON")
+		for _, e := range q.Tests {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", e.ID, e.Added.Local().Format(time.DateOnly), e.Reason)
+		}
+		return w.Flush()
+	},
+}
+
+var quarantineDetectCmd = &cobra.Command{
+	Use:   "detect",
+	Short: "Find flaky tests in the run history",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		runs, err := history.Open(historyDir).List()
+		if err != nil {
+			return err
+		}
+		flaky := history.Flaky(runs)
+		if len(flaky) == 0 {
+			fmt.Println("No flaky tests found.")
+			return nil
+		}
+		fmt.Println("Flaky tests:")
+		for _, f := range flaky {
+			fmt.Printf("- %s: %s\n", f.ID, flakyReason(f))
+		}
+		if !quarantineAdd {
+			return nil
+		}
+		return editQuarantine(func(q *quarantine.List) {
+			for _, f := range flaky {
+				q.Add(f.ID, "flaky: "+flakyReason(f), time.Now())
+			}
+		})
+	},
+}
+
+var trainCmd = &cobra.Command{
+	Use:   "train",
+	Short: "Train the ml selection model on the run history",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		runs, err := history.Open(historyDir).List()
+		if err != nil {
+			return err
+		}
+		tests, err := testmeta.Extract()
+		if err != nil {
+			return err
+		}
+		// Each run's features come from the log up to its base, so training
+		// does not see commits made after the run.
+		ext := &ml.Extractor{
+			Module:    testmeta.ModulePath("."),
+			MaxFiles:  cochangeMaxFiles,
+			CommitsAt: func(rev string) ([][]string, error) { return diff.CommitFiles(rev, cochangeCommits) },
+		}
+		m, err := ml.Fit(ext.Dataset(runs, tests))
+		if err != nil {
+			return err
+		}
+		m.Trained = time.Now().UTC().Truncate(time.Second)
+		if err := m.Save(modelPath); err != nil {
+			return err
+		}
+		fmt.Printf("Trained on %d results from %d runs, %d failing.\n", m.Examples, len(runs), m.Failures)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "FEATURE\tWEIGHT")
+		for i, f := range m.Features {
+			fmt.Fprintf(w, "%s\t%+.3f\n", f, m.Weights[i])
+		}
+		fmt.Fprintf(w, "bias\t%+.3f\n", m.Bias)
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("Saved to %s\n", modelPath)
+		return nil
+	},
+}
+
+var fakeLLMCmd = &cobra.Command{
+	Use:   "fake-llm",
+	Short: "Serve scripted LLM answers for offline runs",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		srv, err := fakellm.New(fakePolicy)
+		if err != nil {
+			return err
+		}
+		if fakeRules != "" {
+			rules, err := fakellm.Load(fakeRules)
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("policy") {
+				rules.Policy = fakePolicy
+			}
+			srv = rules
+		}
+		host, port, err := net.SplitHostPort(fakeListen)
+		if err != nil {
+			return fmt.Errorf("--listen: %w", err)
+		}
+		// A wildcard address listens on every interface; localhost reaches it.
+		if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+			host = "localhost"
+		}
+		log.Printf("fake-llm: listening on %s; point mango at it with --base-url http://%s/v1", fakeListen, net.JoinHostPort(host, port))
+		return http.ListenAndServe(fakeListen, srv)
+	},
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/plan"
+)
+
+// parseShard parses --shard. Without it the run is a single shard. Shards
+// need --plan-file: runners selecting on their own could pick different
+// tests and balance them with different histories, dropping or repeating
+// tests.
+func parseShard() (index, count int, err error) {
+	if shard == "" {
+		return 1, 1, nil
+	}
+	if planFile == "" {
+		return 0, 0, fmt.Errorf("--shard needs --plan-file so every runner splits the same selection; write one with mango plan")
+	}
+	return plan.ParseShard(shard)
+}
+
+// loadPlan reads a plan and warns when it was made for another commit.
+func loadPlan(path string) (*plan.Plan, error) {
+	p, err := plan.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if head, _, err := diff.Commits("HEAD"); err == nil && p.Head != "" && p.Head != head {
+		log.Printf("plan: made for %s, HEAD is %s", short(p.Head), short(head))
+	}
+	return p, nil
+}
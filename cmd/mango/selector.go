@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/example/mango/internal/history"
+	"github.com/example/mango/internal/llm"
+	"github.com/example/mango/internal/llmselector"
+)
+
+// Strategy names accepted in --fallback besides the LLM providers.
+const (
+	strategyStatic   = "static"
+	strategyAll      = "all"
+	strategyEnsemble = "ensemble"
+	strategyHistory  = "history"
+	strategyCoChange = "cochange"
+	strategyML       = "ml"
+)
+
+// sampleTemperature is used for repeated ensemble samples when no
+// --temperature is set, so the samples can differ.
+const sampleTemperature = 0.7
+
+// fallbackChain returns the strategy names to try in order. Without
+// --fallback the --provider model is tried first and every test runs if it
+// fails.
+func fallbackChain() []string {
+	if fallback == "" {
+		if len(ensemble) > 0 {
+			return []string{strategyEnsemble, strategyAll}
+		}
+		return []string{provider, strategyAll}
+	}
+	var names []string
+	for _, n := range strings.Split(strings.ReplaceAll(fallback, "->", ","), ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// newSelector returns the test selector for run and dry-run.
+func newSelector() (llmselector.Selector, error) {
+	return buildSelector(fallbackChain())
+}
+
+// buildSelector chains the named strategies behind the safety net.
+func buildSelector(names []string) (llmselector.Selector, error) {
+	chain := llmselector.Chain{Strict: strict}
+	for _, name := range names {
+		sel, err := newStrategy(name)
+		if err != nil {
+			return nil, err
+		}
+		// The label stamps selections with the model that made them.
+		chain.Strategies = append(chain.Strategies, llmselector.Strategy{Name: strategyLabel(name), Selector: sel})
+	}
+	if !safetyNet && len(alwaysRun) == 0 {
+		return chain, nil
+	}
+	return llmselector.SafetyNet{Next: chain, AlwaysRun: alwaysRun, AlwaysRunOnly: !safetyNet}, nil
+}
+
+// newStrategy builds a single named strategy. Providers may name a model,
+// e.g. openai/gpt-4o-mini.
+func newStrategy(name string) (llmselector.Selector, error) {
+	switch name {
+	case strategyStatic:
+		return llmselector.Static{}, nil
+	case strategyAll:
+		return llmselector.All{}, nil
+	case strategyEnsemble:
+		return newEnsemble()
+	case strategyCoChange:
+		return llmselector.CoChange{
+			Support:    cochangeSupport,
+			Confidence: cochangeConfidence,
+			Commits:    cochangeCommits,
+			MaxFiles:   cochangeMaxFiles,
+		}, nil
+	case strategyML:
+		return llmselector.ML{
+			Path:      modelPath,
+			Store:     history.Open(historyDir),
+			Threshold: mlThreshold,
+			Max:       mlTop,
+			Commits:   cochangeCommits,
+			MaxFiles:  cochangeMaxFiles,
+		}, nil
+	case strategyHistory:
+		return llmselector.History{
+			Store:     history.Open(historyDir),
+			Threshold: historyThreshold,
+			Max:       historyTop,
+			Window:    historyWindow,
+			HalfLife:  historyHalfLife,
+		}, nil
+	}
+	p, m, _ := strings.Cut(name, "/")
+	if !isProvider(llm.Provider(p)) {
+		return nil, fmt.Errorf("unknown selection strategy %q", name)
+	}
+	opts := llmOptions(llm.Provider(p))
+	if m != "" {
+		opts.Model = m
+	}
+	return newLLMSelector(llm.Provider(p), opts), nil
+}
+
+// strategyLabel names a strategy with the model it uses, e.g. openai becomes
+// openai/gpt-4o.
+func strategyLabel(name string) string {
+	p := llm.Provider(name)
+	if !isProvider(p) {
+		return name
+	}
+	return name + "/" + llmOptions(p).WithDefaults(p).Model
+}
+
+// newEnsemble builds the --ensemble selector. Every provider is sampled
+// --samples times; other strategies, such as history, vote once.
+func newEnsemble() (llmselector.Selector, error) {
+	if len(ensemble) == 0 {
+		return nil, fmt.Errorf("the %s strategy needs --ensemble", strategyEnsemble)
+	}
+	e := llmselector.Ensemble{Vote: vote, Quorum: quorum}
+	for _, name := range ensemble {
+		name = strings.TrimSpace(name)
+		p := llm.Provider(name)
+		if !isProvider(p) {
+			if name == strategyEnsemble {
+				return nil, fmt.Errorf("an ensemble cannot contain itself")
+			}
+			sel, err := newStrategy(name)
+			if err != nil {
+				return nil, fmt.Errorf("ensemble member: %w", err)
+			}
+			e.Members = append(e.Members, llmselector.Strategy{Name: name, Selector: sel})
+			continue
+		}
+		opts := llmOptions(p)
+		if samples > 1 && opts.Temperature == nil {
+			t := sampleTemperature
+			opts.Temperature = &t
+		}
+		for i := 1; i <= max(samples, 1); i++ {
+			// A distinct cache key keeps cached samples from collapsing
+			// into one answer.
+			opts.CacheKey = fmt.Sprintf("sample-%d", i)
+			e.Members = append(e.Members, llmselector.Strategy{Name: fmt.Sprintf("%s#%d", p, i), Selector: newLLMSelector(p, opts)})
+		}
+	}
+	return e, nil
+}
+
+// newLLMSelector returns a selector asking p. A provider that cannot be
+// configured, e.g. for lack of a token, becomes a selector that fails so the
+// chain can log it and move on.
+func newLLMSelector(p llm.Provider, opts llm.Options) llmselector.Selector {
+	client, err := llm.New(p, tokenFor(p), opts)
+	if err != nil {
+		return llmselector.Unavailable(err)
+	}
+	resolved := opts.WithDefaults(p)
+	return llmselector.New(client, llmselector.Options{
+		ContextTokens: resolved.ContextTokens,
+		MaxTokens:     resolved.MaxTokens,
+		Concurrency:   concurrency,
+		Hierarchical:  hierarchical,
+		MinConfidence: minConfidence,
+		MaxSelections: maxSelections,
+	})
+}
+
+func isProvider(p llm.Provider) bool {
+	for _, known := range llm.Providers() {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
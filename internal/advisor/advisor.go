@@ -6,47 +6,17 @@ import (
 	"os/exec"
 	"strings"
 
-	openai "github.com/sashabaranov/go-openai"
+	"github.com/example/mango/internal/llm"
+	"github.com/example/mango/internal/prompt"
 )
 
-// Client abstracts chat completion.
-type Client interface {
-	ChatCompletion(ctx context.Context, prompt string) (string, error)
-}
-
-// OpenAIClient uses OpenAI for suggestions.
-type OpenAIClient struct{ client *openai.Client }
-
-func NewOpenAIClient(token string) *OpenAIClient {
-	if token == "" {
-		return nil
-	}
-	return &OpenAIClient{client: openai.NewClient(token)}
-}
-
-func (c *OpenAIClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
-	req := openai.ChatCompletionRequest{
-		Model:     openai.GPT3Dot5Turbo,
-		Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: prompt}},
-		MaxTokens: 512,
-	}
-	resp, err := c.client.CreateChatCompletion(ctx, req)
-	if err != nil {
-		return "", err
-	}
-	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("empty response")
-	}
-	return resp.Choices[0].Message.Content, nil
-}
-
 // Advisor provides code quality advice based on vet/test results.
 type Advisor struct {
-	Client Client
+	Client llm.Client
 	Run    func(ctx context.Context, name string, args ...string) ([]byte, error)
 }
 
-func New(client Client) *Advisor {
+func New(client llm.Client) *Advisor {
 	return &Advisor{Client: client, Run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
 		return exec.CommandContext(ctx, name, args...).CombinedOutput()
 	}}
@@ -66,16 +36,13 @@ func (a Advisor) Advise(ctx context.Context) (string, error) {
 	vetOut, _ := run(ctx, "go", "vet", "./...")
 	testOut, _ := run(ctx, "go", "test", "./...")
 
-	var b strings.Builder
-	b.WriteString("go vet output:\n")
-	b.Write(vetOut)
-	b.WriteString("\n\nTest output:\n")
-	b.Write(testOut)
-	b.WriteString("\nProvide refactoring suggestions and code quality advice.")
-
-	resp, err := a.Client.ChatCompletion(ctx, b.String())
+	text, err := prompt.Render(prompt.Advise, prompt.AdviceData{VetOutput: string(vetOut), TestOutput: string(testOut)})
+	if err != nil {
+		return "", err
+	}
+	resp, err := a.Client.ChatCompletion(ctx, llm.Request{Prompt: text})
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimSpace(resp), nil
+	return strings.TrimSpace(resp.Text), nil
 }
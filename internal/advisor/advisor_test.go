@@ -6,20 +6,20 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-)
 
-type fakeClient struct {
-	resp string
-	err  error
-}
+	"github.com/example/mango/internal/llm"
+	"github.com/example/mango/internal/llm/llmfakes"
+)
 
-func (f fakeClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
-	return f.resp, f.err
+func fakeClient(resp string) *llmfakes.FakeClient {
+	c := &llmfakes.FakeClient{}
+	c.ChatCompletionReturns(llm.Response{Text: resp}, nil)
+	return c
 }
 
 var _ = Describe("Advisor", func() {
 	It("returns suggestions", func() {
-		a := New(fakeClient{resp: "refactor"})
+		a := New(fakeClient("refactor"))
 		a.Run = func(ctx context.Context, name string, args ...string) ([]byte, error) { return []byte("ok"), nil }
 		msg, err := a.Advise(context.Background())
 		Expect(err).NotTo(HaveOccurred())
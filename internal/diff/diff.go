@@ -3,6 +3,9 @@ package diff
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
@@ -107,3 +110,98 @@ func changedFunctions(file string, lines []int) ([]string, error) {
 	}
 	return funcs, nil
 }
+
+// Patch returns the full git diff for diffRange.
+func Patch(diffRange string) (string, error) {
+	if diffRange == "" {
+		diffRange = "HEAD~1"
+	}
+	out, err := exec.Command("git", "diff", diffRange).Output()
+	if err != nil {
+		return "", fmt.Errorf("git diff %s: %w", diffRange, err)
+	}
+	return string(out), nil
+}
+
+// Fingerprint identifies the code in the working tree: the HEAD commit plus
+// uncommitted changes to tracked files. Runs with equal fingerprints tested
+// the same code.
+func Fingerprint() (string, error) {
+	head, err := revParse("HEAD")
+	if err != nil {
+		return "", err
+	}
+	changes, err := exec.Command("git", "diff", "HEAD", "--binary").Output()
+	if err != nil {
+		return "", fmt.Errorf("git diff HEAD: %w", err)
+	}
+	h := sha256.New()
+	h.Write([]byte(head))
+	h.Write(changes)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CommitFiles returns the files touched by each of the last limit non-merge
+// commits reachable from rev, newest first. An empty rev means HEAD and zero
+// means no limit.
+func CommitFiles(rev string, limit int) ([][]string, error) {
+	args := []string{"log", "--no-merges", "--name-only", "--format=%x00"}
+	if limit > 0 {
+		args = append(args, "-n", strconv.Itoa(limit))
+	}
+	if rev != "" {
+		args = append(args, rev, "--")
+	}
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log: %w", err)
+	}
+	var commits [][]string
+	for _, block := range strings.Split(string(out), "\x00") {
+		var files []string
+		for _, f := range strings.Split(block, "\n") {
+			if f = strings.TrimSpace(f); f != "" {
+				files = append(files, f)
+			}
+		}
+		if len(files) > 0 {
+			commits = append(commits, files)
+		}
+	}
+	return commits, nil
+}
+
+// Commits resolves the two ends of diffRange to commit SHAs. A single
+// revision is compared with the working tree, reported as an empty head.
+func Commits(diffRange string) (base, head string, err error) {
+	if diffRange == "" {
+		diffRange = "HEAD~1"
+	}
+	from, to, isRange := strings.Cut(diffRange, "...")
+	if !isRange {
+		from, to, isRange = strings.Cut(diffRange, "..")
+	}
+	if base, err = revParse(from); err != nil {
+		return "", "", err
+	}
+	if isRange {
+		if to == "" {
+			to = "HEAD"
+		}
+		if head, err = revParse(to); err != nil {
+			return "", "", err
+		}
+	}
+	return base, head, nil
+}
+
+func revParse(rev string) (string, error) {
+	if rev == "" {
+		rev = "HEAD"
+	}
+	out, err := exec.Command("git", "rev-parse", "--verify", rev+"^{commit}").Output()
+	if err != nil {
+		return "", fmt.Errorf("resolve %s: %w", rev, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
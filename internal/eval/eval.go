@@ -0,0 +1,357 @@
+// Package eval replays historical commits to measure how well selection
+// strategies pick the tests that actually failed.
+package eval
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/executor"
+	"github.com/example/mango/internal/history"
+	"github.com/example/mango/internal/llmselector"
+	"github.com/example/mango/internal/orchestrator"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// Evaluator checks every strategy against the full test suite of each
+// commit in a range.
+type Evaluator struct {
+	// Strategies are compared side by side; Name labels them in the report,
+	// e.g. openai/gpt-4o.
+	Strategies []llmselector.Strategy
+	// History supplies recorded full-suite results, so suites need not be
+	// run again, and receives the results of suites that were run. Optional.
+	History *history.Store
+	// Mode is the execution mode for full-suite runs: auto, go, ginkgo.
+	Mode string
+	// Retries reruns failed tests so that flaky failures do not count
+	// against the strategies.
+	Retries int
+}
+
+// Summary holds the scores of every strategy over a commit range.
+type Summary struct {
+	Commits []string
+	// Skipped commits could not be checked out or their suite did not run.
+	Skipped []string
+	// Failures counts failing tests over all evaluated commits.
+	Failures int
+	Scores   []*Score
+}
+
+// Score is the result of one strategy.
+type Score struct {
+	Name string
+	// Errors counts commits on which the strategy failed to select.
+	Errors int
+	// Selected counts selected tests that have a full-suite result.
+	Selected int
+	// Failures and Caught count failing tests, and those that were selected,
+	// on commits the strategy answered.
+	Failures int
+	Caught   int
+	// SelectedTime and SuiteTime sum the durations of the selected tests
+	// and of the full suites.
+	SelectedTime time.Duration
+	SuiteTime    time.Duration
+	Missed       []Miss
+}
+
+// Miss is a failing test a strategy did not select.
+type Miss struct {
+	Commit string
+	Test   string
+}
+
+// Recall is the share of failing tests that were selected. It is 1 when
+// nothing failed.
+func (s *Score) Recall() float64 {
+	if s.Failures == 0 {
+		return 1
+	}
+	return float64(s.Caught) / float64(s.Failures)
+}
+
+// Precision is the share of selected tests that failed.
+func (s *Score) Precision() float64 {
+	if s.Selected == 0 {
+		return 0
+	}
+	return float64(s.Caught) / float64(s.Selected)
+}
+
+// Savings is the share of full-suite test time not spent running the
+// selected tests.
+func (s *Score) Savings() float64 {
+	if s.SuiteTime == 0 {
+		return 0
+	}
+	return 1 - float64(s.SelectedTime)/float64(s.SuiteTime)
+}
+
+// add scores one commit: selected are the IDs the strategy picked and
+// results the full-suite outcome.
+func (s *Score) add(commit string, selected []string, results []history.Result) {
+	picked := map[string]bool{}
+	for _, id := range selected {
+		picked[id] = true
+	}
+	for _, r := range results {
+		s.SuiteTime += time.Duration(r.Duration)
+		if picked[r.ID] {
+			s.Selected++
+			s.SelectedTime += time.Duration(r.Duration)
+		}
+		if r.Outcome != executor.OutcomeFail {
+			continue
+		}
+		s.Failures++
+		if picked[r.ID] {
+			s.Caught++
+		} else {
+			s.Missed = append(s.Missed, Miss{Commit: commit, Test: r.ID})
+		}
+	}
+}
+
+// Commits lists the non-merge commits in rangeSpec, oldest first.
+func Commits(rangeSpec string) ([]string, error) {
+	out, err := exec.Command("git", "rev-list", "--reverse", "--no-merges", rangeSpec).Output()
+	if err != nil {
+		return nil, fmt.Errorf("list commits in %s: %w", rangeSpec, err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// Evaluate scores every strategy on every commit in rangeSpec. Each commit
+// is checked out in a temporary worktree, so the working directory is left
+// alone, and compared with its parent. The process works inside that
+// worktree while a commit is evaluated.
+func (e Evaluator) Evaluate(ctx context.Context, rangeSpec string) (*Summary, error) {
+	commits, err := Commits(rangeSpec)
+	if err != nil {
+		return nil, err
+	}
+	summary := &Summary{}
+	for _, st := range e.Strategies {
+		summary.Scores = append(summary.Scores, &Score{Name: st.Name})
+	}
+	for i, c := range commits {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		log.Printf("eval: commit %s (%d/%d)", short(c), i+1, len(commits))
+		if err := e.evaluateCommit(ctx, c, commits[i:], summary); err != nil {
+			log.Printf("eval: skipping %s: %v", short(c), err)
+			summary.Skipped = append(summary.Skipped, c)
+			continue
+		}
+		summary.Commits = append(summary.Commits, c)
+	}
+	return summary, nil
+}
+
+// evaluateCommit scores commit. Strategies select as of its parent: runs of
+// commit and the later ones, runs recorded after it was made and the commit
+// itself in the git log are hidden from them.
+func (e Evaluator) evaluateCommit(ctx context.Context, commit string, later []string, summary *Summary) error {
+	dir, err := os.MkdirTemp("", "mango-eval-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if out, err := exec.Command("git", "worktree", "add", "--detach", dir, commit).CombinedOutput(); err != nil {
+		return fmt.Errorf("check out: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	defer exec.Command("git", "worktree", "remove", "--force", dir).Run()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	defer os.Chdir(wd)
+
+	diffRange := commit + "^.." + commit
+	changes, err := diff.AnalyzeDiff(diffRange)
+	if err != nil {
+		return fmt.Errorf("diff: %w", err)
+	}
+	tests, err := testmeta.Extract()
+	if err != nil {
+		return err
+	}
+	snapshot := llmselector.Snapshot{Heads: later, Rev: commit + "^"}
+	if snapshot.Before, err = commitTime(commit); err != nil {
+		return err
+	}
+	results, run, err := e.suite(ctx, commit, changes, tests)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Outcome == executor.OutcomeFail {
+			summary.Failures++
+		}
+	}
+
+	selectCtx := llmselector.WithSnapshot(ctx, snapshot)
+	for i, st := range e.Strategies {
+		selected, err := st.Selector.Select(selectCtx, changes, tests)
+		if err != nil {
+			log.Printf("eval: %s failed on %s: %v", st.Name, short(commit), err)
+			summary.Scores[i].Errors++
+			continue
+		}
+		ids := make([]string, len(selected))
+		for j, s := range selected {
+			ids[j] = s.Test.ID()
+		}
+		summary.Scores[i].add(short(commit), ids, results)
+	}
+	// Recorded only now, so no strategy saw the answer.
+	if run != nil {
+		if err := e.History.Save(run); err != nil {
+			log.Printf("eval: could not record suite of %s: %v", short(commit), err)
+		}
+	}
+	return nil
+}
+
+// commitTime is when commit was made.
+func commitTime(commit string) (time.Time, error) {
+	out, err := exec.Command("git", "show", "-s", "--format=%cI", commit).Output()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("commit time of %s: %w", short(commit), err)
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(out)))
+}
+
+// suite returns the full-suite results of commit, from the history when a
+// complete run was recorded and by running every test otherwise. A suite that
+// ran is returned as a run for the caller to record.
+func (e Evaluator) suite(ctx context.Context, commit string, changes []diff.Change, tests []testmeta.Metadata) ([]history.Result, *history.Run, error) {
+	if e.History != nil {
+		if results, ok := e.recorded(commit, tests); ok {
+			return results, nil, nil
+		}
+	}
+	all := make([]llmselector.Selection, len(tests))
+	for i, t := range tests {
+		all[i] = llmselector.Selection{Test: t, Source: "all"}
+	}
+	start := time.Now()
+	results, runErr := orchestrator.Orchestrator{Mode: e.Mode, Retries: e.Retries}.Execute(ctx, all)
+	if len(results) == 0 && runErr != nil {
+		return nil, nil, fmt.Errorf("test suite: %w", runErr)
+	}
+	if e.History == nil {
+		return results, nil, nil
+	}
+	run := &history.Run{
+		Range:    short(commit) + "^.." + short(commit),
+		Head:     commit,
+		Provider: "all",
+		Changes:  changes,
+		Results:  results,
+		Duration: history.Duration(time.Since(start).Round(time.Millisecond)),
+	}
+	if tree, err := diff.Fingerprint(); err == nil {
+		run.Tree = tree
+	}
+	if parent, err := exec.Command("git", "rev-parse", commit+"^").Output(); err == nil {
+		run.Base = strings.TrimSpace(string(parent))
+	}
+	for _, t := range tests {
+		run.Candidates = append(run.Candidates, t.ID())
+		run.Selected = append(run.Selected, history.Selected{ID: t.ID(), Source: "all"})
+	}
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+	return results, run, nil
+}
+
+// recorded finds a run of commit with a result for every current test. A
+// shadow run of one shard, or a run cut short by a budget or a failure, is
+// incomplete and would hide misses.
+func (e Evaluator) recorded(commit string, tests []testmeta.Metadata) ([]history.Result, bool) {
+	runs, err := e.History.List()
+	if err != nil {
+		log.Printf("eval: history: %v", err)
+		return nil, false
+	}
+	for _, r := range runs {
+		if r.Head != commit || len(r.Results) == 0 {
+			continue
+		}
+		ran := map[string]bool{}
+		for _, res := range r.Results {
+			ran[res.ID] = true
+		}
+		complete := true
+		for _, t := range tests {
+			if !ran[t.ID()] {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return r.Results, true
+		}
+	}
+	return nil, false
+}
+
+// Write prints the scores as a table followed by the missed failures.
+func (r *Summary) Write(w io.Writer) error {
+	fmt.Fprintf(w, "Evaluated %d commits", len(r.Commits))
+	if len(r.Skipped) > 0 {
+		fmt.Fprintf(w, " (%d skipped)", len(r.Skipped))
+	}
+	fmt.Fprintf(w, " with %d failing tests.\n\n", r.Failures)
+
+	scores := append([]*Score(nil), r.Scores...)
+	sort.SliceStable(scores, func(i, j int) bool { return scores[i].Recall() > scores[j].Recall() })
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STRATEGY\tRECALL\tPRECISION\tTIME SAVED\tSELECTED\tCAUGHT\tMISSED\tERRORS")
+	for _, s := range scores {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\n", s.Name, percent(s.Recall()), percent(s.Precision()), percent(s.Savings()),
+			s.Selected, s.Caught, len(s.Missed), s.Errors)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, s := range scores {
+		if len(s.Missed) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\nMissed by %s:\n", s.Name)
+		for _, m := range s.Missed {
+			fmt.Fprintf(w, "- %s %s\n", m.Commit, m.Test)
+		}
+	}
+	return nil
+}
+
+func percent(f float64) string {
+	return fmt.Sprintf("%.1f%%", f*100)
+}
+
+func short(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
@@ -0,0 +1,93 @@
+package eval
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/example/mango/internal/history"
+	"github.com/example/mango/internal/testmeta"
+)
+
+var _ = Describe("Score", func() {
+	suite := []history.Result{
+		{ID: "a:TestA", Outcome: "fail", Duration: history.Duration(time.Second)},
+		{ID: "a:TestB", Outcome: "pass", Duration: history.Duration(time.Second)},
+		{ID: "b:TestC", Outcome: "fail", Duration: history.Duration(2 * time.Second)},
+		{ID: "c:TestD", Outcome: "pass", Duration: history.Duration(4 * time.Second)},
+	}
+
+	It("measures recall, precision and time saved", func() {
+		s := &Score{Name: "openai/gpt-4o"}
+		s.add("abc", []string{"a:TestA", "a:TestB", "x:TestGone"}, suite)
+		Expect(s.Recall()).To(Equal(0.5))
+		Expect(s.Precision()).To(Equal(0.5))
+		Expect(s.Savings()).To(Equal(0.75))
+		Expect(s.Missed).To(Equal([]Miss{{Commit: "abc", Test: "b:TestC"}}))
+	})
+
+	It("accumulates over commits", func() {
+		s := &Score{}
+		s.add("abc", []string{"a:TestA", "b:TestC"}, suite)
+		s.add("def", nil, suite[1:2])
+		Expect(s.Recall()).To(Equal(1.0))
+		Expect(s.SuiteTime).To(Equal(9 * time.Second))
+	})
+
+	It("counts full recall when nothing failed", func() {
+		s := &Score{}
+		s.add("abc", nil, suite[1:2])
+		Expect(s.Recall()).To(Equal(1.0))
+		Expect(s.Precision()).To(BeZero())
+		Expect(s.Savings()).To(Equal(1.0))
+	})
+})
+
+var _ = Describe("Summary", func() {
+	It("ranks strategies by recall and lists missed failures", func() {
+		weak := &Score{Name: "static", Selected: 2, Failures: 2, Caught: 1, SelectedTime: time.Second, SuiteTime: 4 * time.Second,
+			Missed: []Miss{{Commit: "abc", Test: "b:TestC"}}}
+		strong := &Score{Name: "openai/gpt-4o", Selected: 4, Failures: 2, Caught: 2, SelectedTime: 2 * time.Second, SuiteTime: 4 * time.Second, Errors: 1}
+		r := &Summary{Commits: []string{"abc", "def"}, Skipped: []string{"ghi"}, Failures: 2, Scores: []*Score{weak, strong}}
+		var out strings.Builder
+		Expect(r.Write(&out)).To(Succeed())
+		Expect(out.String()).To(Equal(`Evaluated 2 commits (1 skipped) with 2 failing tests.
+
+STRATEGY       RECALL  PRECISION  TIME SAVED  SELECTED  CAUGHT  MISSED  ERRORS
+openai/gpt-4o  100.0%  50.0%      50.0%       4         2       0       1
+static         50.0%   50.0%      75.0%       2         1       1       0
+
+Missed by static:
+- abc b:TestC
+`))
+	})
+})
+
+var _ = Describe("recorded", func() {
+	tests := []testmeta.Metadata{{Name: "TestA", File: "a/a_test.go"}, {Name: "TestB", File: "b/b_test.go"}}
+	result := func(id string) history.Result { return history.Result{ID: id, Outcome: "pass"} }
+
+	It("only reuses runs with a result for every test", func() {
+		store := history.Open(GinkgoT().TempDir())
+		now := time.Now()
+		all := []history.Selected{{ID: "a:TestA"}, {ID: "b:TestB"}}
+		Expect(store.Save(&history.Run{Time: now, Head: "abc", Shadow: true, Selected: all[:1], Results: []history.Result{result("a:TestA")}})).To(Succeed())
+		Expect(store.Save(&history.Run{Time: now.Add(-time.Minute), Head: "abc", Selected: all, Results: []history.Result{result("a:TestA")}})).To(Succeed())
+		e := Evaluator{History: store}
+		_, ok := e.recorded("abc", tests)
+		Expect(ok).To(BeFalse())
+
+		Expect(store.Save(&history.Run{Time: now.Add(-time.Hour), Head: "abc", Shadow: true, Selected: all[:1], Results: []history.Result{result("a:TestA"), result("b:TestB")}})).To(Succeed())
+		results, ok := e.recorded("abc", tests)
+		Expect(ok).To(BeTrue())
+		Expect(results).To(HaveLen(2))
+	})
+})
+
+func TestEval(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Eval Suite")
+}
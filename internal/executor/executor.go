@@ -1,35 +1,250 @@
 package executor
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
-// RunGoTests runs go tests matching the given regex in the specified package.
-func RunGoTests(ctx context.Context, pkg string, tests []string) error {
+// Test outcomes reported in a Result.
+const (
+	OutcomePass = "pass"
+	OutcomeFail = "fail"
+	OutcomeSkip = "skip"
+	// OutcomeFlaky marks a test that failed and then passed on a retry.
+	OutcomeFlaky = "flaky"
+)
+
+// Output receives the output of the tests.
+var Output io.Writer = os.Stdout
+
+// Result is the outcome of one requested test.
+type Result struct {
+	Test     string
+	Outcome  string
+	Duration time.Duration
+	// Attempts counts the runs of the test, retries included.
+	Attempts int
+}
+
+// RunGoTests runs go tests matching the given regex in the specified package
+// and returns the outcome of every top-level test that ran. Failed tests
+// are run again up to retries times before they count as failures. With
+// failFast the package stops at the first failing test.
+func RunGoTests(ctx context.Context, pkg string, tests []string, retries int, failFast bool) ([]Result, error) {
+	return withRetries(pkg, tests, retries, func(names []string) ([]Result, error) {
+		return runGoTests(ctx, pkg, names, failFast)
+	})
+}
+
+func runGoTests(ctx context.Context, pkg string, tests []string, failFast bool) ([]Result, error) {
 	if len(tests) == 0 {
-		return nil
+		return nil, nil
 	}
 	regex := fmt.Sprintf("^(%s)$", strings.Join(tests, "|"))
-	args := []string{"test", pkg, "-run", regex}
+	args := []string{"test", "-json", target(pkg), "-run", regex}
+	if failFast {
+		args = append(args, "-failfast")
+	}
 	cmd := exec.CommandContext(ctx, "go", args...)
-	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	results := parseTestEvents(out, Output)
+	return results, cmd.Wait()
 }
 
-// RunGinkgo runs ginkgo tests focusing on the provided expressions.
-func RunGinkgo(ctx context.Context, pkg string, focuses []string) error {
+// testEvent is a line of go test -json output.
+type testEvent struct {
+	Action  string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// parseTestEvents echoes the test output in r to w and collects the results
+// of top-level tests. Lines that are not events are echoed as they are.
+func parseTestEvents(r io.Reader, w io.Writer) []Result {
+	var results []Result
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var ev testEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			fmt.Fprintln(w, scanner.Text())
+			continue
+		}
+		if ev.Action == "output" {
+			fmt.Fprint(w, ev.Output)
+			continue
+		}
+		if ev.Test == "" || strings.Contains(ev.Test, "/") {
+			continue
+		}
+		switch ev.Action {
+		case OutcomePass, OutcomeFail, OutcomeSkip:
+			results = append(results, Result{Test: ev.Test, Outcome: ev.Action, Duration: seconds(ev.Elapsed)})
+		}
+	}
+	return results
+}
+
+// RunGinkgo runs ginkgo tests focusing on the provided expressions and
+// returns the outcome of every focus. A focus fails when any spec under it
+// fails and takes the summed spec durations. Failed focuses are run again up
+// to retries times before they count as failures. With failFast the package
+// stops at the first failing spec.
+func RunGinkgo(ctx context.Context, pkg string, focuses []string, retries int, failFast bool) ([]Result, error) {
+	return withRetries(pkg, focuses, retries, func(names []string) ([]Result, error) {
+		return runGinkgo(ctx, pkg, names, failFast)
+	})
+}
+
+func runGinkgo(ctx context.Context, pkg string, focuses []string, failFast bool) ([]Result, error) {
 	if len(focuses) == 0 {
-		return nil
+		return nil, nil
+	}
+	report, err := os.CreateTemp("", "mango-ginkgo-*.json")
+	if err != nil {
+		return nil, err
 	}
+	report.Close()
+	defer os.Remove(report.Name())
+
 	focus := strings.Join(focuses, "|")
-	args := []string{"test", pkg, "-ginkgo.focus", focus}
+	args := []string{"test", target(pkg), "-ginkgo.focus", focus, "-ginkgo.json-report", report.Name()}
+	if failFast {
+		args = append(args, "-ginkgo.fail-fast")
+	}
 	cmd := exec.CommandContext(ctx, "go", args...)
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = Output
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	runErr := cmd.Run()
+
+	data, err := os.ReadFile(report.Name())
+	if err != nil || len(data) == 0 {
+		return nil, runErr
+	}
+	results, err := parseGinkgoReport(data, focuses)
+	if err != nil && runErr == nil {
+		runErr = err
+	}
+	return results, runErr
+}
+
+// ginkgoReport holds the parts of a ginkgo JSON report mango reads.
+type ginkgoReport struct {
+	SpecReports []struct {
+		ContainerHierarchyTexts []string
+		LeafNodeType            string
+		LeafNodeText            string
+		State                   string
+		RunTime                 time.Duration
+	}
+}
+
+func parseGinkgoReport(data []byte, focuses []string) ([]Result, error) {
+	var reports []ginkgoReport
+	if err := json.Unmarshal(data, &reports); err != nil {
+		return nil, fmt.Errorf("ginkgo report: %w", err)
+	}
+	var results []Result
+	for _, f := range focuses {
+		res := Result{Test: f, Outcome: OutcomeSkip}
+		found := false
+		for _, r := range reports {
+			for _, s := range r.SpecReports {
+				if s.LeafNodeType != "It" || (s.LeafNodeText != f && !contains(s.ContainerHierarchyTexts, f)) {
+					continue
+				}
+				found = true
+				res.Duration += s.RunTime
+				switch s.State {
+				case "passed":
+					if res.Outcome == OutcomeSkip {
+						res.Outcome = OutcomePass
+					}
+				case "skipped", "pending":
+				default:
+					res.Outcome = OutcomeFail
+				}
+			}
+		}
+		if found {
+			results = append(results, res)
+		}
+	}
+	return results, nil
+}
+
+// withRetries runs tests and then reruns the failed ones up to retries
+// times. Tests passing on a retry are flaky. The error of the last attempt
+// is returned, so recovered failures do not fail the run.
+func withRetries(pkg string, tests []string, retries int, run func([]string) ([]Result, error)) ([]Result, error) {
+	results, err := run(tests)
+	for i := range results {
+		results[i].Attempts = 1
+	}
+	for attempt := 1; attempt <= retries && err != nil; attempt++ {
+		index := map[string]int{}
+		var failed []string
+		for i, r := range results {
+			if r.Outcome == OutcomeFail {
+				index[r.Test] = i
+				failed = append(failed, r.Test)
+			}
+		}
+		if len(failed) == 0 {
+			// Nothing to retry, e.g. the package does not build.
+			break
+		}
+		fmt.Fprintf(Output, "Retrying %d failed tests in %s (retry %d of %d)\n", len(failed), pkg, attempt, retries)
+		var rerun []Result
+		rerun, err = run(failed)
+		for _, r := range rerun {
+			i, ok := index[r.Test]
+			if !ok {
+				continue
+			}
+			results[i].Attempts++
+			results[i].Duration += r.Duration
+			if r.Outcome == OutcomePass {
+				results[i].Outcome = OutcomeFlaky
+			}
+		}
+	}
+	return results, err
+}
+
+// target turns a package directory into a pattern go test accepts.
+func target(pkg string) string {
+	if filepath.IsAbs(pkg) || strings.HasPrefix(pkg, ".") {
+		return pkg
+	}
+	return "./" + pkg
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,121 @@
+package executor
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("parseTestEvents", func() {
+	It("echoes output and collects top-level results", func() {
+		events := strings.Join([]string{
+			`{"Action":"run","Test":"TestA"}`,
+			`{"Action":"output","Test":"TestA","Output":"=== RUN   TestA\n"}`,
+			`{"Action":"pass","Test":"TestA/sub","Elapsed":0.1}`,
+			`{"Action":"pass","Test":"TestA","Elapsed":0.25}`,
+			`{"Action":"fail","Test":"TestB","Elapsed":1}`,
+			`{"Action":"skip","Test":"TestC"}`,
+			`{"Action":"fail","Elapsed":1.3}`,
+			`# example.com/broken`,
+		}, "\n")
+		var out strings.Builder
+		results := parseTestEvents(strings.NewReader(events), &out)
+		Expect(results).To(Equal([]Result{
+			{Test: "TestA", Outcome: OutcomePass, Duration: 250 * time.Millisecond},
+			{Test: "TestB", Outcome: OutcomeFail, Duration: time.Second},
+			{Test: "TestC", Outcome: OutcomeSkip},
+		}))
+		Expect(out.String()).To(Equal("=== RUN   TestA\n# example.com/broken\n"))
+	})
+})
+
+var _ = Describe("parseGinkgoReport", func() {
+	It("folds specs into the focus that selected them", func() {
+		report := `[{"SpecReports":[
+			{"ContainerHierarchyTexts":["Cache"],"LeafNodeType":"It","LeafNodeText":"hits","State":"passed","RunTime":1000000},
+			{"ContainerHierarchyTexts":["Cache"],"LeafNodeType":"It","LeafNodeText":"evicts","State":"failed","RunTime":2000000},
+			{"ContainerHierarchyTexts":["Meter"],"LeafNodeType":"It","LeafNodeText":"prices","State":"passed","RunTime":3000000},
+			{"LeafNodeType":"BeforeSuite","State":"passed"}
+		]}]`
+		results, err := parseGinkgoReport([]byte(report), []string{"Cache", "prices", "Missing"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results).To(Equal([]Result{
+			{Test: "Cache", Outcome: OutcomeFail, Duration: 3 * time.Millisecond},
+			{Test: "prices", Outcome: OutcomePass, Duration: 3 * time.Millisecond},
+		}))
+	})
+})
+
+var _ = Describe("withRetries", func() {
+	BeforeEach(func() {
+		old := Output
+		Output = io.Discard
+		DeferCleanup(func() { Output = old })
+	})
+
+	// script answers attempt i with the outcomes in outcomes[i], keyed by
+	// test, failing the attempt when any test fails.
+	script := func(outcomes ...map[string]string) (func([]string) ([]Result, error), *[][]string) {
+		var calls [][]string
+		return func(names []string) ([]Result, error) {
+			calls = append(calls, names)
+			var rs []Result
+			var err error
+			for _, n := range names {
+				o := outcomes[len(calls)-1][n]
+				if o == OutcomeFail {
+					err = errors.New("exit status 1")
+				}
+				rs = append(rs, Result{Test: n, Outcome: o, Duration: time.Second})
+			}
+			return rs, err
+		}, &calls
+	}
+
+	It("reruns failures and marks recovered tests flaky", func() {
+		run, calls := script(
+			map[string]string{"TestA": OutcomePass, "TestB": OutcomeFail, "TestC": OutcomeFail},
+			map[string]string{"TestB": OutcomePass, "TestC": OutcomeFail},
+			map[string]string{"TestC": OutcomeFail},
+		)
+		results, err := withRetries("pkg", []string{"TestA", "TestB", "TestC"}, 2, run)
+		Expect(err).To(HaveOccurred())
+		Expect(*calls).To(Equal([][]string{{"TestA", "TestB", "TestC"}, {"TestB", "TestC"}, {"TestC"}}))
+		Expect(results).To(Equal([]Result{
+			{Test: "TestA", Outcome: OutcomePass, Duration: time.Second, Attempts: 1},
+			{Test: "TestB", Outcome: OutcomeFlaky, Duration: 2 * time.Second, Attempts: 2},
+			{Test: "TestC", Outcome: OutcomeFail, Duration: 3 * time.Second, Attempts: 3},
+		}))
+	})
+
+	It("succeeds once every failure recovers", func() {
+		run, calls := script(
+			map[string]string{"TestA": OutcomeFail},
+			map[string]string{"TestA": OutcomePass},
+		)
+		results, err := withRetries("pkg", []string{"TestA"}, 3, run)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*calls).To(HaveLen(2))
+		Expect(results[0].Outcome).To(Equal(OutcomeFlaky))
+	})
+
+	It("does not retry packages that fail without test failures", func() {
+		calls := 0
+		_, err := withRetries("pkg", []string{"TestA"}, 3, func([]string) ([]Result, error) {
+			calls++
+			return nil, errors.New("build failed")
+		})
+		Expect(err).To(MatchError("build failed"))
+		Expect(calls).To(Equal(1))
+	})
+})
+
+func TestExecutor(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Executor Suite")
+}
@@ -0,0 +1,382 @@
+// Package fakellm serves scripted answers on the OpenAI, Anthropic and
+// Gemini endpoints mango calls, so the orchestrator can run end to end
+// without network access.
+package fakellm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policies answer requests no rule matched.
+const (
+	// PolicyAll selects every candidate listed in the prompt.
+	PolicyAll = "all"
+	// PolicyNone selects nothing.
+	PolicyNone = "none"
+	// PolicyError fails the request with HTTP 500.
+	PolicyError = "error"
+)
+
+// Rule scripts the answer for prompts matching a regular expression.
+type Rule struct {
+	// Match is a regular expression searched for in the prompt.
+	Match string `yaml:"match"`
+	// Answer is returned as the tool call arguments, or as text for
+	// requests without a tool. Strings are returned verbatim and anything
+	// else is encoded as JSON.
+	Answer interface{} `yaml:"answer"`
+	// Policy answers with a policy instead of a fixed answer.
+	Policy string `yaml:"policy"`
+	// Status fails the request with this HTTP status, e.g. 429 or 529, to
+	// exercise error handling.
+	Status int `yaml:"status"`
+
+	re *regexp.Regexp
+}
+
+// Server is an http.Handler faking the LLM providers. The first rule whose
+// pattern matches the prompt answers; Policy answers everything else.
+type Server struct {
+	Rules  []Rule `yaml:"rules"`
+	Policy string `yaml:"policy"`
+}
+
+// Load reads a rules file such as
+//
+//	policy: all
+//	rules:
+//	  - match: internal/auth
+//	    answer: {"selections": [{"id": "internal/auth:TestLogin", "reason": "auth changed", "confidence": 0.9}]}
+//	  - match: docs/
+//	    policy: none
+func Load(path string) (*Server, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Server
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// New returns a server answering every request with policy.
+func New(policy string) (*Server, error) {
+	s := &Server{Policy: policy}
+	return s, s.compile()
+}
+
+func (s *Server) compile() error {
+	if !validPolicy(s.Policy) {
+		return fmt.Errorf("unknown policy %q", s.Policy)
+	}
+	for i := range s.Rules {
+		re, err := regexp.Compile(s.Rules[i].Match)
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if !validPolicy(s.Rules[i].Policy) {
+			return fmt.Errorf("rule %d: unknown policy %q", i+1, s.Rules[i].Policy)
+		}
+		s.Rules[i].re = re
+	}
+	return nil
+}
+
+func validPolicy(p string) bool {
+	switch p {
+	case "", PolicyAll, PolicyNone, PolicyError:
+		return true
+	}
+	return false
+}
+
+func (r Rule) matches(prompt string) bool {
+	if r.re != nil {
+		return r.re.MatchString(prompt)
+	}
+	ok, _ := regexp.MatchString(r.Match, prompt)
+	return ok
+}
+
+// request is what the fake needs from a provider request.
+type request struct {
+	prompt string
+	tool   string
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var (
+		req    request
+		render func(answer string) interface{}
+		err    error
+	)
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/chat/completions"):
+		req, err = decodeOpenAI(r)
+		render = func(a string) interface{} { return openAIResponse(req, a) }
+	case strings.HasSuffix(r.URL.Path, "/messages"):
+		req, err = decodeAnthropic(r)
+		render = func(a string) interface{} { return anthropicResponse(req, a) }
+	case strings.HasSuffix(r.URL.Path, ":generateContent"):
+		req, err = decodeGemini(r)
+		render = func(a string) interface{} { return geminiResponse(req, a) }
+	default:
+		writeError(w, http.StatusNotFound, "unknown endpoint "+r.URL.Path)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	answer, status := s.answer(req)
+	log.Printf("fake-llm: %s %s -> %d", r.Method, r.URL.Path, status)
+	if status != http.StatusOK {
+		writeError(w, status, "scripted failure")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(render(answer))
+}
+
+// answer returns the scripted answer for req and the HTTP status to send.
+func (s *Server) answer(req request) (string, int) {
+	for _, rule := range s.Rules {
+		if !rule.matches(req.prompt) {
+			continue
+		}
+		switch {
+		case rule.Status != 0:
+			return "", rule.Status
+		case rule.Policy != "":
+			return policyAnswer(rule.Policy, req)
+		case rule.Answer != nil:
+			return encode(rule.Answer), http.StatusOK
+		}
+	}
+	return policyAnswer(s.Policy, req)
+}
+
+// policyAnswer builds the answer for a policy. Candidates are read from the
+// "- item" lines of the prompt: test IDs for select_tests, import paths for
+// select_packages and every item for plain requests.
+func policyAnswer(policy string, req request) (string, int) {
+	switch policy {
+	case PolicyAll, "":
+	case PolicyNone:
+		if req.tool == "" {
+			return "[]", http.StatusOK
+		}
+		return `{"selections":[]}`, http.StatusOK
+	case PolicyError:
+		return "", http.StatusInternalServerError
+	}
+
+	items := candidates(req)
+	if req.tool == "" {
+		return encode(items), http.StatusOK
+	}
+	type selection struct {
+		ID         string  `json:"id"`
+		Reason     string  `json:"reason"`
+		Confidence float64 `json:"confidence"`
+	}
+	out := struct {
+		Selections []selection `json:"selections"`
+	}{Selections: []selection{}}
+	for _, id := range items {
+		out.Selections = append(out.Selections, selection{ID: id, Reason: "fake-llm policy " + PolicyAll, Confidence: 1})
+	}
+	return encode(out), http.StatusOK
+}
+
+var (
+	// Ginkgo IDs contain spaces; "- file.go: Func" change lines do not
+	// follow the colon directly.
+	testItem    = regexp.MustCompile(`(?m)^- (\S+:\S.*?)\s*$`)
+	packageItem = regexp.MustCompile(`(?m)^- (\S+) \(\d+ tests\)`)
+	anyItem     = regexp.MustCompile(`(?m)^- (\S+)\s*$`)
+)
+
+func candidates(req request) []string {
+	re := anyItem
+	switch req.tool {
+	case "select_tests":
+		re = testItem
+	case "select_packages":
+		re = packageItem
+	}
+	items := []string{}
+	for _, m := range re.FindAllStringSubmatch(req.prompt, -1) {
+		items = append(items, m[1])
+	}
+	return items
+}
+
+func encode(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"message": msg, "code": status},
+	})
+}
+
+func decodeOpenAI(r *http.Request) (request, error) {
+	var body struct {
+		Messages []struct {
+			Content string `json:"content"`
+		} `json:"messages"`
+		Tools []struct {
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		} `json:"tools"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return request{}, err
+	}
+	var req request
+	for _, m := range body.Messages {
+		req.prompt += m.Content
+	}
+	if len(body.Tools) > 0 {
+		req.tool = body.Tools[0].Function.Name
+	}
+	return req, nil
+}
+
+func openAIResponse(req request, answer string) interface{} {
+	msg := map[string]interface{}{"role": "assistant"}
+	if req.tool == "" {
+		msg["content"] = answer
+	} else {
+		msg["tool_calls"] = []map[string]interface{}{{
+			"id":       "call_fake",
+			"type":     "function",
+			"function": map[string]string{"name": req.tool, "arguments": answer},
+		}}
+	}
+	in, out := usage(req, answer)
+	return map[string]interface{}{
+		"object":  "chat.completion",
+		"choices": []map[string]interface{}{{"index": 0, "message": msg}},
+		"usage":   map[string]int{"prompt_tokens": in, "completion_tokens": out, "total_tokens": in + out},
+	}
+}
+
+func decodeAnthropic(r *http.Request) (request, error) {
+	var body struct {
+		Messages []struct {
+			Content string `json:"content"`
+		} `json:"messages"`
+		Tools []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return request{}, err
+	}
+	var req request
+	for _, m := range body.Messages {
+		req.prompt += m.Content
+	}
+	if len(body.Tools) > 0 {
+		req.tool = body.Tools[0].Name
+	}
+	return req, nil
+}
+
+// anthropicResponse answers with a tool_use block when the answer is a JSON
+// object and with text otherwise, like a model ignoring the tool.
+func anthropicResponse(req request, answer string) interface{} {
+	block := map[string]interface{}{"type": "text", "text": answer}
+	var input map[string]interface{}
+	if req.tool != "" && json.Unmarshal([]byte(answer), &input) == nil {
+		block = map[string]interface{}{"type": "tool_use", "id": "toolu_fake", "name": req.tool, "input": input}
+	}
+	in, out := usage(req, answer)
+	return map[string]interface{}{
+		"type":    "message",
+		"role":    "assistant",
+		"content": []interface{}{block},
+		"usage":   map[string]int{"input_tokens": in, "output_tokens": out},
+	}
+}
+
+func decodeGemini(r *http.Request) (request, error) {
+	var body struct {
+		Contents []struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"contents"`
+		GenerationConfig struct {
+			ResponseSchema map[string]interface{} `json:"responseSchema"`
+		} `json:"generationConfig"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return request{}, err
+	}
+	var req request
+	for _, c := range body.Contents {
+		for _, p := range c.Parts {
+			req.prompt += p.Text
+		}
+	}
+	// Gemini requests carry a schema rather than a tool name; tell test and
+	// package selection apart by the prompt.
+	if body.GenerationConfig.ResponseSchema != nil {
+		req.tool = "select_tests"
+		if packageItem.MatchString(req.prompt) {
+			req.tool = "select_packages"
+		}
+	}
+	return req, nil
+}
+
+func geminiResponse(req request, answer string) interface{} {
+	in, out := usage(req, answer)
+	return map[string]interface{}{
+		"candidates": []map[string]interface{}{{
+			"content": map[string]interface{}{
+				"role":  "model",
+				"parts": []map[string]string{{"text": answer}},
+			},
+		}},
+		"usageMetadata": map[string]int{"promptTokenCount": in, "candidatesTokenCount": out, "totalTokenCount": in + out},
+	}
+}
+
+// usage reports token counts at roughly four characters per token, so
+// cost accounting can be exercised offline.
+func usage(req request, answer string) (prompt, completion int) {
+	return (len(req.prompt) + 3) / 4, (len(answer) + 3) / 4
+}
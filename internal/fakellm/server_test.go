@@ -0,0 +1,108 @@
+package fakellm
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/llm"
+	"github.com/example/mango/internal/llmselector"
+	"github.com/example/mango/internal/testmeta"
+)
+
+var _ = Describe("Server", func() {
+	tests := []testmeta.Metadata{
+		{Name: "TestLogin", File: "internal/auth/auth_test.go"},
+		{Name: "TestDocs", File: "docs/docs_test.go"},
+	}
+	changes := []diff.Change{{File: "internal/auth/auth.go"}}
+
+	serve := func(s *Server) string {
+		srv := httptest.NewServer(s)
+		DeferCleanup(srv.Close)
+		return srv.URL
+	}
+
+	selectWith := func(p llm.Provider, url string) ([]llmselector.Selection, error) {
+		client, err := llm.New(p, "", llm.Options{BaseURL: url, Retry: &llm.RetryPolicy{}})
+		Expect(err).NotTo(HaveOccurred())
+		return llmselector.New(client, llmselector.Options{}).Select(context.Background(), changes, tests)
+	}
+
+	DescribeTable("selects every test with the all policy",
+		func(p llm.Provider, base string) {
+			srv, err := New(PolicyAll)
+			Expect(err).NotTo(HaveOccurred())
+			selected, err := selectWith(p, serve(srv)+base)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(selected).To(HaveLen(2))
+			Expect(selected[0].Reason).To(Equal("fake-llm policy all"))
+		},
+		Entry("openai", llm.ProviderOpenAI, "/v1"),
+		Entry("anthropic", llm.ProviderAnthropic, "/v1"),
+		Entry("gemini", llm.ProviderGemini, "/v1beta"),
+	)
+
+	It("selects Ginkgo specs whose names contain spaces", func() {
+		srv, err := New(PolicyAll)
+		Expect(err).NotTo(HaveOccurred())
+		client, err := llm.New(llm.ProviderOpenAI, "", llm.Options{BaseURL: serve(srv) + "/v1", Retry: &llm.RetryPolicy{}})
+		Expect(err).NotTo(HaveOccurred())
+		spec := testmeta.Metadata{Name: "Auth logs users in", File: "internal/auth/auth_test.go", Ginkgo: true}
+		withFuncs := []diff.Change{{File: "internal/auth/auth.go", Functions: []string{"Login"}}}
+		selected, err := llmselector.New(client, llmselector.Options{}).Select(context.Background(), withFuncs, []testmeta.Metadata{spec})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(selected).To(HaveLen(1))
+		Expect(selected[0].Test).To(Equal(spec))
+		Expect(selected[0].Reason).To(Equal("fake-llm policy all"))
+	})
+
+	It("answers from the first matching rule", func() {
+		file := filepath.Join(GinkgoT().TempDir(), "rules.yaml")
+		os.WriteFile(file, []byte(`policy: error
+rules:
+  - match: internal/auth
+    answer: {"selections": [{"id": "internal/auth:TestLogin", "reason": "auth changed", "confidence": 0.9}]}
+  - match: .*
+    policy: none
+`), 0o644)
+		srv, err := Load(file)
+		Expect(err).NotTo(HaveOccurred())
+		selected, err := selectWith(llm.ProviderAnthropic, serve(srv))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(selected).To(ConsistOf(llmselector.Selection{Test: tests[0], Reason: "auth changed", Confidence: 0.9}))
+	})
+
+	It("fails requests with a scripted status", func() {
+		srv := &Server{Rules: []Rule{{Match: "auth", Status: 529}}}
+		_, err := selectWith(llm.ProviderOpenAI, serve(srv))
+		Expect(errors.Is(err, llm.ErrOverloaded)).To(BeTrue())
+	})
+
+	It("returns the listed items as a JSON array to plain requests", func() {
+		srv, err := New(PolicyAll)
+		Expect(err).NotTo(HaveOccurred())
+		client, err := llm.New(llm.ProviderGemini, "", llm.Options{BaseURL: serve(srv)})
+		Expect(err).NotTo(HaveOccurred())
+		resp, err := client.ChatCompletion(context.Background(), llm.Request{Prompt: "Available tests:\n- TestA\n- TestB\n"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Text).To(MatchJSON(`["TestA","TestB"]`))
+	})
+
+	It("rejects unknown policies", func() {
+		_, err := New("some")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func TestFakeLLM(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "FakeLLM Suite")
+}
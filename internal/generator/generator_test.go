@@ -8,21 +8,20 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/llm"
+	"github.com/example/mango/internal/llm/llmfakes"
 	"github.com/example/mango/internal/testmeta"
 )
 
-type fakeClient struct {
-	resp string
-	err  error
-}
-
-func (f fakeClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
-	return f.resp, f.err
+func fakeClient(resp string) *llmfakes.FakeClient {
+	c := &llmfakes.FakeClient{}
+	c.ChatCompletionReturns(llm.Response{Text: resp}, nil)
+	return c
 }
 
 var _ = Describe("Generator", func() {
 	It("parses LLM suggestions", func() {
-		g := New(fakeClient{resp: `["A","B"]`})
+		g := New(fakeClient(`["A","B"]`))
 		names, err := g.Generate(context.Background(), []diff.Change{{File: "a.go"}}, []testmeta.Metadata{})
 		Expect(err).NotTo(HaveOccurred())
 		Expect(names).To(ConsistOf("A", "B"))
@@ -0,0 +1,364 @@
+// Package history keeps a local record of every mango run: what changed,
+// which tests were candidates, which were selected and how they fared.
+package history
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/executor"
+)
+
+// DefaultDir is where runs are stored, relative to the repository.
+const DefaultDir = ".mango/history"
+
+// Export formats.
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// ErrNotFound is returned by Get for unknown run IDs.
+var ErrNotFound = errors.New("run not found")
+
+// Run is the record of one mango run.
+type Run struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Range is the diff range as given; Base and Head are the commits it
+	// resolved to. Head is empty when the diff was against the working tree.
+	Range string `json:"range"`
+	Base  string `json:"base,omitempty"`
+	Head  string `json:"head,omitempty"`
+	// Tree fingerprints the code that was tested, uncommitted changes
+	// included. Flaky tests pass and fail on the same tree.
+	Tree     string        `json:"tree,omitempty"`
+	Provider string        `json:"provider,omitempty"`
+	Model    string        `json:"model,omitempty"`
+	Changes  []diff.Change `json:"changes"`
+	// Candidates are the IDs of every test that could have been selected.
+	Candidates []string   `json:"candidates"`
+	Selected   []Selected `json:"selected"`
+	Results    []Result   `json:"results"`
+	Duration   Duration   `json:"duration"`
+	// Error is set when the run did not finish cleanly, e.g. a test failed.
+	Error string `json:"error,omitempty"`
+
+	// Shadow is set when the unselected tests ran after the selected ones,
+	// so Results cover the full suite.
+	Shadow bool `json:"shadow,omitempty"`
+	// Escapes are failing tests the selection left out, found by a shadow
+	// run. Diff and Prompt are kept alongside them to tune prompts and rules.
+	Escapes []string `json:"escapes,omitempty"`
+	Diff    string   `json:"diff,omitempty"`
+	Prompt  string   `json:"prompt,omitempty"`
+}
+
+// Selected is a selected test and why it was picked.
+type Selected struct {
+	ID         string   `json:"id"`
+	Reason     string   `json:"reason,omitempty"`
+	Confidence float64  `json:"confidence"`
+	Source     string   `json:"source,omitempty"`
+	Rules      []string `json:"rules,omitempty"`
+	// Votes is how many of Voters ensemble members selected the test, so
+	// disagreement between them stays on record.
+	Votes  int `json:"votes,omitempty"`
+	Voters int `json:"voters,omitempty"`
+}
+
+// Result is the outcome of a test that ran.
+type Result struct {
+	ID       string   `json:"id"`
+	Outcome  string   `json:"outcome"`
+	Duration Duration `json:"duration"`
+	// Attempts counts the runs of the test when it was retried.
+	Attempts int `json:"attempts,omitempty"`
+	// Quarantined results did not fail the run.
+	Quarantined bool `json:"quarantined,omitempty"`
+}
+
+// Duration is a time.Duration stored as a readable string such as "1.5s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = Duration(v)
+	return err
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Failed returns the IDs of the tests that failed.
+func (r Run) Failed() []string {
+	var ids []string
+	for _, res := range r.Results {
+		if res.Outcome == executor.OutcomeFail {
+			ids = append(ids, res.ID)
+		}
+	}
+	return ids
+}
+
+// FlakyTest is a test whose outcome changed without the code changing.
+type FlakyTest struct {
+	ID string
+	// Flips counts trees on which the test both passed and failed.
+	Flips int
+	// Retried counts runs in which it failed and then passed on a retry.
+	Retried int
+}
+
+// Flaky finds tests that passed and failed on the same code, in one run
+// thanks to retries or across runs of the same tree. The flakiest come
+// first.
+func Flaky(runs []Run) []FlakyTest {
+	type outcomes struct{ pass, fail bool }
+	seen := map[[2]string]*outcomes{}
+	found := map[string]*FlakyTest{}
+	get := func(id string) *FlakyTest {
+		if found[id] == nil {
+			found[id] = &FlakyTest{ID: id}
+		}
+		return found[id]
+	}
+	for _, r := range runs {
+		for _, res := range r.Results {
+			if res.Outcome == executor.OutcomeFlaky {
+				get(res.ID).Retried++
+			}
+			if r.Tree == "" {
+				continue
+			}
+			key := [2]string{r.Tree, res.ID}
+			o := seen[key]
+			if o == nil {
+				o = &outcomes{}
+				seen[key] = o
+			}
+			before := o.pass && o.fail
+			switch res.Outcome {
+			case executor.OutcomePass:
+				o.pass = true
+			case executor.OutcomeFail:
+				o.fail = true
+			case executor.OutcomeFlaky:
+				o.pass, o.fail = true, true
+			}
+			if !before && o.pass && o.fail && res.Outcome != executor.OutcomeFlaky {
+				get(res.ID).Flips++
+			}
+		}
+	}
+	var out []FlakyTest
+	for _, f := range found {
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if a, b := out[i].Flips+out[i].Retried, out[j].Flips+out[j].Retried; a != b {
+			return a > b
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// EscapeStats summarises shadow runs: how often a failure was missed by the
+// selection.
+type EscapeStats struct {
+	ShadowRuns      int
+	RunsWithEscapes int
+	// Failures counts failing tests in shadow runs, Escapes those that were
+	// not selected.
+	Failures int
+	Escapes  int
+}
+
+// Rate is the share of failures that escaped the selection.
+func (s EscapeStats) Rate() float64 {
+	if s.Failures == 0 {
+		return 0
+	}
+	return float64(s.Escapes) / float64(s.Failures)
+}
+
+// Escapes summarises the shadow runs among runs.
+func Escapes(runs []Run) EscapeStats {
+	var s EscapeStats
+	for _, r := range runs {
+		if !r.Shadow {
+			continue
+		}
+		s.ShadowRuns++
+		s.Failures += len(r.Failed())
+		s.Escapes += len(r.Escapes)
+		if len(r.Escapes) > 0 {
+			s.RunsWithEscapes++
+		}
+	}
+	return s
+}
+
+// Store keeps runs as JSON files in a directory, one per run, so that no
+// database server or lock is needed and concurrent runs cannot corrupt each
+// other.
+type Store struct {
+	Dir string
+
+	now func() time.Time
+}
+
+// Open returns the store rooted at dir. The directory is created on the
+// first Save.
+func Open(dir string) *Store {
+	return &Store{Dir: dir, now: time.Now}
+}
+
+// Save assigns r an ID and time, unless already set, and writes it.
+func (s *Store) Save(r *Run) error {
+	if r.Time.IsZero() {
+		r.Time = s.now()
+	}
+	if r.ID == "" {
+		r.ID = fmt.Sprintf("%s-%04x", r.Time.UTC().Format("20060102T150405"), r.Time.Nanosecond()>>10&0xffff)
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(r.ID))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
+
+// List returns every stored run, newest first.
+func (s *Store) List() ([]Run, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []Run
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		r, err := s.read(filepath.Join(s.Dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Time.After(runs[j].Time) })
+	return runs, nil
+}
+
+// Get returns the run with the given ID or unique ID prefix.
+func (s *Store) Get(id string) (Run, error) {
+	if r, err := s.read(s.path(id)); err == nil {
+		return r, nil
+	}
+	runs, err := s.List()
+	if err != nil {
+		return Run{}, err
+	}
+	var found []Run
+	for _, r := range runs {
+		if strings.HasPrefix(r.ID, id) {
+			found = append(found, r)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Run{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	case 1:
+		return found[0], nil
+	}
+	return Run{}, fmt.Errorf("run ID %s is ambiguous: %d runs match", id, len(found))
+}
+
+func (s *Store) read(path string) (Run, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Run{}, err
+	}
+	var r Run
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Run{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Export writes runs to w as a JSON array, or in CSV with one row per test
+// result.
+func Export(w io.Writer, runs []Run, format string) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if runs == nil {
+			runs = []Run{}
+		}
+		return enc.Encode(runs)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"run", "time", "range", "base", "head", "provider", "model", "test", "selected", "outcome", "seconds"})
+		for _, r := range runs {
+			selected := map[string]bool{}
+			for _, s := range r.Selected {
+				selected[s.ID] = true
+			}
+			for _, res := range r.Results {
+				cw.Write([]string{
+					r.ID, r.Time.UTC().Format(time.RFC3339), r.Range, r.Base, r.Head, r.Provider, r.Model,
+					res.ID, strconv.FormatBool(selected[res.ID]), res.Outcome,
+					strconv.FormatFloat(time.Duration(res.Duration).Seconds(), 'f', 3, 64),
+				})
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
@@ -0,0 +1,150 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/example/mango/internal/diff"
+)
+
+var _ = Describe("Store", func() {
+	var (
+		store *Store
+		now   time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		store = Open(filepath.Join(GinkgoT().TempDir(), "history"))
+		store.now = func() time.Time { return now }
+	})
+
+	run := func() *Run {
+		return &Run{
+			Range:      "HEAD~1",
+			Base:       "0123456789abcdef",
+			Provider:   "openai",
+			Changes:    []diff.Change{{File: "pkg/a/a.go", Functions: []string{"A"}}},
+			Candidates: []string{"pkg/a:TestA", "pkg/b:TestB"},
+			Selected:   []Selected{{ID: "pkg/a:TestA", Reason: "calls A", Confidence: 0.9, Source: "openai"}},
+			Results:    []Result{{ID: "pkg/a:TestA", Outcome: "fail", Duration: Duration(1500 * time.Millisecond)}},
+			Duration:   Duration(2 * time.Second),
+		}
+	}
+
+	It("saves and reads back runs, newest first", func() {
+		first := run()
+		Expect(store.Save(first)).To(Succeed())
+		now = now.Add(time.Hour)
+		second := run()
+		second.Range = "main..HEAD"
+		Expect(store.Save(second)).To(Succeed())
+		Expect(first.ID).To(HavePrefix("20240501T120000-"))
+
+		runs, err := store.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runs).To(HaveLen(2))
+		Expect(runs[0].Range).To(Equal("main..HEAD"))
+		Expect(runs[1].Results).To(Equal(first.Results))
+		Expect(runs[1].Failed()).To(Equal([]string{"pkg/a:TestA"}))
+	})
+
+	It("finds runs by unique ID prefix", func() {
+		r := run()
+		Expect(store.Save(r)).To(Succeed())
+		got, err := store.Get(r.ID[:17])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got.ID).To(Equal(r.ID))
+
+		_, err = store.Get("1999")
+		Expect(err).To(MatchError(ErrNotFound))
+	})
+
+	It("lists nothing before the first run", func() {
+		runs, err := store.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runs).To(BeEmpty())
+	})
+
+	It("keeps no temporary files", func() {
+		Expect(store.Save(run())).To(Succeed())
+		entries, _ := os.ReadDir(store.Dir)
+		Expect(entries).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Escapes", func() {
+	It("summarises shadow runs only", func() {
+		failed := func(ids ...string) []Result {
+			var rs []Result
+			for _, id := range ids {
+				rs = append(rs, Result{ID: id, Outcome: "fail"})
+			}
+			return rs
+		}
+		stats := Escapes([]Run{
+			{Shadow: true, Results: failed("a:TestA", "b:TestB"), Escapes: []string{"b:TestB"}},
+			{Shadow: true, Results: failed("a:TestA")},
+			{Shadow: true},
+			{Results: failed("c:TestC")},
+		})
+		Expect(stats).To(Equal(EscapeStats{ShadowRuns: 3, RunsWithEscapes: 1, Failures: 3, Escapes: 1}))
+		Expect(stats.Rate()).To(BeNumerically("~", 1.0/3))
+	})
+})
+
+var _ = Describe("Flaky", func() {
+	It("finds tests that flip on the same tree or pass on a retry", func() {
+		res := func(id, outcome string) Result { return Result{ID: id, Outcome: outcome} }
+		flaky := Flaky([]Run{
+			{Tree: "t1", Results: []Result{res("a:TestA", "pass"), res("b:TestB", "fail"), res("c:TestC", "pass")}},
+			{Tree: "t1", Results: []Result{res("a:TestA", "fail"), res("b:TestB", "fail")}},
+			{Tree: "t1", Results: []Result{res("a:TestA", "pass")}},
+			{Tree: "t2", Results: []Result{res("a:TestA", "fail"), res("c:TestC", "fail")}},
+			{Tree: "t2", Results: []Result{res("a:TestA", "pass"), res("d:TestD", "flaky")}},
+			{Results: []Result{res("d:TestD", "flaky"), res("c:TestC", "pass")}},
+		})
+		Expect(flaky).To(Equal([]FlakyTest{
+			{ID: "a:TestA", Flips: 2},
+			{ID: "d:TestD", Retried: 2},
+		}))
+	})
+})
+
+var _ = Describe("Export", func() {
+	runs := []Run{{
+		ID: "r1", Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Range: "HEAD~1", Provider: "openai", Model: "gpt-4o",
+		Selected: []Selected{{ID: "a:TestA"}},
+		Results: []Result{
+			{ID: "a:TestA", Outcome: "pass", Duration: Duration(250 * time.Millisecond)},
+			{ID: "b:TestB", Outcome: "fail", Duration: Duration(time.Second)},
+		},
+	}}
+
+	It("writes one CSV row per result", func() {
+		var out strings.Builder
+		Expect(Export(&out, runs, FormatCSV)).To(Succeed())
+		Expect(out.String()).To(Equal(`run,time,range,base,head,provider,model,test,selected,outcome,seconds
+r1,2024-05-01T12:00:00Z,HEAD~1,,,openai,gpt-4o,a:TestA,true,pass,0.250
+r1,2024-05-01T12:00:00Z,HEAD~1,,,openai,gpt-4o,b:TestB,false,fail,1.000
+`))
+	})
+
+	It("writes JSON with readable durations", func() {
+		var out strings.Builder
+		Expect(Export(&out, runs, FormatJSON)).To(Succeed())
+		Expect(out.String()).To(ContainSubstring(`"duration": "250ms"`))
+		Expect(Export(&out, runs, "xml")).To(HaveOccurred())
+	})
+})
+
+func TestHistory(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "History Suite")
+}
@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+func init() {
+	Register(ProviderAnthropic, Options{
+		Model:         "claude-3-opus-20240229",
+		BaseURL:       "https://api.anthropic.com/v1",
+		MaxTokens:     4096,
+		ContextTokens: 200000,
+	}, func(token string, opts Options) (Client, error) {
+		return NewAnthropicClient(token, opts), nil
+	})
+}
+
+// AnthropicClient implements Client using the Anthropic messages API.
+type AnthropicClient struct {
+	token string
+	opts  Options
+}
+
+// NewAnthropicClient returns a Client for Anthropic Claude. opts should
+// already have the provider defaults applied.
+func NewAnthropicClient(token string, opts Options) *AnthropicClient {
+	return &AnthropicClient{token: token, opts: opts}
+}
+
+// ChatCompletion sends the prompt and returns the text answer or the
+// requested tool input.
+func (c *AnthropicClient) ChatCompletion(ctx context.Context, r Request) (Response, error) {
+	body := map[string]interface{}{
+		"model":      c.opts.Model,
+		"max_tokens": c.opts.MaxTokens,
+		"messages": []map[string]string{{
+			"role":    "user",
+			"content": r.Prompt,
+		}},
+	}
+	if t := c.opts.Temperature; t != nil {
+		body["temperature"] = *t
+	}
+	if r.Tool != nil {
+		body["tools"] = []map[string]interface{}{{
+			"name":         r.Tool.Name,
+			"description":  r.Tool.Description,
+			"input_schema": r.Tool.Schema,
+		}}
+		body["tool_choice"] = map[string]string{"type": "tool", "name": r.Tool.Name}
+	}
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.BaseURL+"/messages", bytes.NewReader(data))
+	if err != nil {
+		return Response{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", c.token)
+	req.Header.Set("anthropic-version", "2023-06-01")
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(ProviderAnthropic, resp); err != nil {
+		return Response{}, err
+	}
+	var out struct {
+		Content []struct {
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Response{}, err
+	}
+	usage := Usage{PromptTokens: out.Usage.InputTokens, CompletionTokens: out.Usage.OutputTokens}
+	for _, block := range out.Content {
+		switch {
+		case r.Tool == nil && block.Type == "text":
+			return Response{Text: block.Text, Usage: usage}, nil
+		case r.Tool != nil && block.Type == "tool_use" && block.Name == r.Tool.Name:
+			return Response{Text: string(block.Input), Usage: usage}, nil
+		}
+	}
+	return Response{}, errors.New("empty response")
+}
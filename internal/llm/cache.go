@@ -0,0 +1,232 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultCacheDir is where responses are cached, relative to the repository.
+const DefaultCacheDir = ".mango/cache"
+
+// Cache stores responses on disk so that identical calls are answered
+// without reaching the provider. Entries are keyed by provider, model,
+// options and a hash of the request.
+type Cache struct {
+	Dir string
+	// TTL expires entries older than this. Zero keeps them forever.
+	TTL time.Duration
+	// MaxBytes evicts the least recently used entries once the cache grows
+	// beyond this size. Zero means no limit.
+	MaxBytes int64
+
+	now func() time.Time
+}
+
+// NewCache returns a cache rooted at dir.
+func NewCache(dir string, ttl time.Duration, maxBytes int64) *Cache {
+	return &Cache{Dir: dir, TTL: ttl, MaxBytes: maxBytes, now: time.Now}
+}
+
+// CacheStats describes the entries on disk. Oldest and Newest are the times
+// entries were last used.
+type CacheStats struct {
+	Entries int
+	Bytes   int64
+	Oldest  time.Time
+	Newest  time.Time
+}
+
+type cacheEntry struct {
+	Provider Provider  `json:"provider"`
+	Model    string    `json:"model"`
+	Created  time.Time `json:"created"`
+	Response Response  `json:"response"`
+}
+
+// Wrap returns a client that answers from the cache and stores the
+// responses of next. opts must have the provider defaults applied.
+func (c *Cache) Wrap(next Client, provider Provider, opts Options) Client {
+	return &cacheClient{next: next, cache: c, provider: provider, opts: opts}
+}
+
+type cacheClient struct {
+	next     Client
+	cache    *Cache
+	provider Provider
+	opts     Options
+}
+
+func (c *cacheClient) ChatCompletion(ctx context.Context, req Request) (Response, error) {
+	key := c.key(req)
+	if resp, ok := c.cache.get(key); ok {
+		return resp, nil
+	}
+	resp, err := c.next.ChatCompletion(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	c.cache.put(key, cacheEntry{Provider: c.provider, Model: c.opts.Model, Created: c.cache.now(), Response: resp})
+	return resp, nil
+}
+
+// key hashes everything that influences the answer.
+func (c *cacheClient) key(req Request) string {
+	data, _ := json.Marshal(struct {
+		Provider    Provider
+		Model       string
+		BaseURL     string
+		Temperature *float64
+		MaxTokens   int
+		CacheKey    string
+		Request     Request
+	}{c.provider, c.opts.Model, c.opts.BaseURL, c.opts.Temperature, c.opts.MaxTokens, c.opts.CacheKey, req})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+func (c *Cache) get(key string) (Response, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return Response{}, false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		os.Remove(c.path(key))
+		return Response{}, false
+	}
+	now := c.now()
+	if c.TTL > 0 && now.Sub(e.Created) > c.TTL {
+		os.Remove(c.path(key))
+		return Response{}, false
+	}
+	// The modification time tracks the last use for eviction.
+	os.Chtimes(c.path(key), now, now)
+	// A cached answer costs nothing.
+	e.Response.Usage = Usage{}
+	return e.Response, true
+}
+
+// put writes the entry and evicts old ones. Failures only cost a cache
+// miss later, so they are ignored.
+func (c *Cache) put(key string, e cacheEntry) {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(c.Dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	os.Chtimes(c.path(key), e.Created, e.Created)
+	c.evict()
+}
+
+type cacheFile struct {
+	path string
+	size int64
+	used time.Time
+}
+
+func (c *Cache) files() ([]cacheFile, error) {
+	entries, err := os.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []cacheFile
+	for _, d := range entries {
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".json") {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, cacheFile{path: filepath.Join(c.Dir, d.Name()), size: info.Size(), used: info.ModTime()})
+	}
+	return out, nil
+}
+
+// evict removes expired entries and, least recently used first, entries
+// beyond MaxBytes.
+func (c *Cache) evict() {
+	files, err := c.files()
+	if err != nil {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	for _, f := range files {
+		expired := c.TTL > 0 && c.now().Sub(f.used) > c.TTL
+		if !expired && (c.MaxBytes <= 0 || total <= c.MaxBytes) {
+			continue
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+		}
+	}
+}
+
+// Stats reports the number and size of cached entries.
+func (c *Cache) Stats() (CacheStats, error) {
+	files, err := c.files()
+	if err != nil {
+		return CacheStats{}, err
+	}
+	var s CacheStats
+	for _, f := range files {
+		s.Entries++
+		s.Bytes += f.size
+		if s.Oldest.IsZero() || f.used.Before(s.Oldest) {
+			s.Oldest = f.used
+		}
+		if f.used.After(s.Newest) {
+			s.Newest = f.used
+		}
+	}
+	return s, nil
+}
+
+// Clear removes every cached entry.
+func (c *Cache) Clear() error {
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Error kinds reported by providers. Use errors.Is to test an error returned
+// by a Client against them.
+var (
+	ErrAuth        = errors.New("authentication failed")
+	ErrRateLimited = errors.New("rate limited")
+	ErrOverloaded  = errors.New("provider overloaded")
+	ErrBadRequest  = errors.New("bad request")
+	ErrServer      = errors.New("provider error")
+)
+
+// APIError is a non-successful HTTP response from a provider.
+type APIError struct {
+	Provider   Provider
+	StatusCode int
+	Message    string
+	// RetryAfter is the delay the provider asked for, or zero.
+	RetryAfter time.Duration
+
+	kind error
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s: %v (HTTP %d)", e.Provider, e.kind, e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Unwrap returns the error kind, e.g. ErrRateLimited.
+func (e *APIError) Unwrap() error { return e.kind }
+
+// Retryable reports whether repeating the call may succeed.
+func (e *APIError) Retryable() bool {
+	return e.kind == ErrRateLimited || e.kind == ErrOverloaded || e.kind == ErrServer
+}
+
+// newAPIError classifies a failed response by status code.
+func newAPIError(provider Provider, status int, message string, retryAfter time.Duration) *APIError {
+	e := &APIError{Provider: provider, StatusCode: status, Message: message, RetryAfter: retryAfter}
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		e.kind = ErrAuth
+	case status == http.StatusTooManyRequests:
+		e.kind = ErrRateLimited
+	case status == http.StatusServiceUnavailable || status == 529:
+		// 529 is Anthropic's "overloaded" status.
+		e.kind = ErrOverloaded
+	case status >= 500:
+		e.kind = ErrServer
+	default:
+		e.kind = ErrBadRequest
+	}
+	return e
+}
+
+// checkResponse turns a non-2xx response into an *APIError carrying the
+// provider's error message and Retry-After hint.
+func checkResponse(provider Provider, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return newAPIError(provider, resp.StatusCode, errorMessage(body), retryAfter(resp.Header.Get("Retry-After")))
+}
+
+// errorMessage extracts the message from the {"error": {"message": ...}}
+// envelope all providers use, falling back to the raw body.
+func errorMessage(body []byte) string {
+	var envelope struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Error.Message != "" {
+		return envelope.Error.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// openAIError converts go-openai errors into an *APIError that asks to wait
+// before retrying.
+func openAIError(err error, wait time.Duration) error {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode > 0 {
+		return newAPIError(ProviderOpenAI, apiErr.HTTPStatusCode, apiErr.Message, wait)
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode > 0 {
+		msg := ""
+		if reqErr.Err != nil {
+			msg = reqErr.Err.Error()
+		}
+		return newAPIError(ProviderOpenAI, reqErr.HTTPStatusCode, msg, wait)
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// promptFilter redacts prompts and optionally prints them as sent.
+type promptFilter struct {
+	next     Client
+	provider Provider
+	model    string
+	redact   func(string) string
+	show     io.Writer
+}
+
+// showMu keeps prompts printed by concurrent calls from interleaving.
+var showMu sync.Mutex
+
+func (f *promptFilter) ChatCompletion(ctx context.Context, req Request) (Response, error) {
+	if f.redact != nil {
+		req.Prompt = f.redact(req.Prompt)
+	}
+	if f.show != nil {
+		showMu.Lock()
+		fmt.Fprintf(f.show, "--- prompt to %s/%s ---\n%s\n--- end of prompt ---\n", f.provider, f.model, req.Prompt)
+		showMu.Unlock()
+	}
+	return f.next.ChatCompletion(ctx, req)
+}
@@ -0,0 +1,113 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	Register(ProviderGemini, Options{
+		Model:         "gemini-pro",
+		BaseURL:       "https://generativelanguage.googleapis.com/v1beta",
+		MaxTokens:     2048,
+		ContextTokens: 30720,
+	}, func(token string, opts Options) (Client, error) {
+		return NewGeminiClient(token, opts), nil
+	})
+}
+
+// GeminiClient implements Client using the Gemini generateContent API.
+type GeminiClient struct {
+	token string
+	opts  Options
+}
+
+// NewGeminiClient returns a Client for Google's Gemini. opts should already
+// have the provider defaults applied.
+func NewGeminiClient(token string, opts Options) *GeminiClient {
+	return &GeminiClient{token: token, opts: opts}
+}
+
+// ChatCompletion sends the prompt and returns the answer. When a Tool is
+// requested the answer is constrained with a response schema.
+func (c *GeminiClient) ChatCompletion(ctx context.Context, r Request) (Response, error) {
+	genConfig := map[string]interface{}{
+		"maxOutputTokens": c.opts.MaxTokens,
+	}
+	if t := c.opts.Temperature; t != nil {
+		genConfig["temperature"] = *t
+	}
+	if r.Tool != nil {
+		genConfig["responseMimeType"] = "application/json"
+		genConfig["responseSchema"] = geminiSchema(r.Tool.Schema)
+	}
+	body := map[string]interface{}{
+		"contents": []map[string]interface{}{
+			{"parts": []map[string]string{{"text": r.Prompt}}},
+		},
+		"generationConfig": genConfig,
+	}
+	data, _ := json.Marshal(body)
+	endpoint := c.opts.BaseURL + "/models/" + url.PathEscape(c.opts.Model) + ":generateContent?key=" + url.QueryEscape(c.token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return Response{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(ProviderGemini, resp); err != nil {
+		return Response{}, err
+	}
+	var out struct {
+		Candidates []struct {
+			Content struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"content"`
+		} `json:"candidates"`
+		UsageMetadata struct {
+			PromptTokenCount     int `json:"promptTokenCount"`
+			CandidatesTokenCount int `json:"candidatesTokenCount"`
+		} `json:"usageMetadata"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Response{}, err
+	}
+	if len(out.Candidates) == 0 || len(out.Candidates[0].Content.Parts) == 0 {
+		return Response{}, errors.New("empty response")
+	}
+	return Response{
+		Text:  out.Candidates[0].Content.Parts[0].Text,
+		Usage: Usage{PromptTokens: out.UsageMetadata.PromptTokenCount, CompletionTokens: out.UsageMetadata.CandidatesTokenCount},
+	}, nil
+}
+
+// geminiSchema converts a JSON schema into Gemini's OpenAPI subset, which
+// spells types in upper case.
+func geminiSchema(schema map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(schema))
+	for k, v := range schema {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			out[k] = geminiSchema(val)
+		case string:
+			if k == "type" {
+				val = strings.ToUpper(val)
+			}
+			out[k] = val
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Client sends chat completion requests to an LLM provider.
+//
+//go:generate counterfeiter . Client
+type Client interface {
+	ChatCompletion(ctx context.Context, req Request) (Response, error)
+}
+
+// Request is a single-turn chat completion.
+type Request struct {
+	Prompt string
+	// Tool, when set, asks the model to answer with arguments that follow
+	// Tool.Schema instead of free text.
+	Tool *Tool
+}
+
+// Tool describes a structured answer the model must produce.
+type Tool struct {
+	Name        string
+	Description string
+	// Schema is a JSON schema for the answer.
+	Schema map[string]interface{}
+}
+
+// Response is the model's answer.
+type Response struct {
+	// Text holds the assistant message, or the JSON arguments of the tool
+	// call when the request named a Tool.
+	Text string
+	// Usage is the token count reported by the provider.
+	Usage Usage
+}
+
+// Usage counts the tokens a call consumed.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Provider names an LLM vendor or API flavour.
+type Provider string
+
+const (
+	ProviderOpenAI    Provider = "openai"
+	ProviderAnthropic Provider = "anthropic"
+	ProviderGemini    Provider = "gemini"
+)
+
+// ErrNoToken is returned when a provider needs a token and none was given.
+var ErrNoToken = errors.New("no LLM token configured")
+
+// Options configures the model a client talks to.
+type Options struct {
+	// Model overrides the provider's default model.
+	Model string
+	// BaseURL overrides the provider's API endpoint, e.g. to reach an
+	// OpenAI-compatible server such as vLLM, llama.cpp or Ollama.
+	BaseURL string
+	// Temperature overrides the provider's default sampling temperature.
+	Temperature *float64
+	// MaxTokens is the completion budget for each answer.
+	MaxTokens int
+	// ContextTokens is the model's context window in tokens.
+	ContextTokens int
+	// HTTPClient sends the requests; http.DefaultClient is used when nil.
+	// Per-call timeouts come from Retry.Timeout.
+	HTTPClient *http.Client
+	// Retry controls retries of failed calls. DefaultRetryPolicy is used
+	// when nil.
+	Retry *RetryPolicy
+	// Cache, when set, answers repeated calls from disk.
+	Cache *Cache
+	// CacheKey distinguishes calls that are otherwise identical, such as
+	// repeated samples of the same prompt.
+	CacheKey string
+	// Meter, when set, records usage and cost and enforces its budget.
+	Meter *Meter
+	// Redact, when set, rewrites every prompt before it leaves the process.
+	Redact func(string) string
+	// ShowPrompt, when set, receives every prompt exactly as it is sent.
+	ShowPrompt io.Writer
+}
+
+// WithDefaults fills zero fields from the defaults registered for provider.
+func (o Options) WithDefaults(provider Provider) Options {
+	def := registry[provider].defaults
+	if o.Model == "" {
+		o.Model = def.Model
+	}
+	if o.BaseURL == "" {
+		o.BaseURL = def.BaseURL
+	}
+	o.BaseURL = strings.TrimSuffix(o.BaseURL, "/")
+	if o.MaxTokens <= 0 {
+		o.MaxTokens = def.MaxTokens
+	}
+	if o.ContextTokens <= 0 {
+		o.ContextTokens = def.ContextTokens
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+	if o.Retry == nil {
+		policy := DefaultRetryPolicy
+		o.Retry = &policy
+	}
+	return o
+}
@@ -0,0 +1,427 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var testTool = &Tool{
+	Name: "select_tests",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"ids": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+	},
+}
+
+var _ = Describe("providers", func() {
+	var (
+		received map[string]interface{}
+		path     string
+	)
+
+	serve := func(reply string) *httptest.Server {
+		received = nil
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			json.NewDecoder(r.Body).Decode(&received)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, reply)
+		}))
+		DeferCleanup(srv.Close)
+		return srv
+	}
+
+	It("registers every built-in provider", func() {
+		Expect(Providers()).To(ConsistOf(ProviderAnthropic, ProviderGemini, ProviderOpenAI))
+	})
+
+	It("requires a token unless a base URL is given", func() {
+		_, err := New(ProviderGemini, "", Options{})
+		Expect(errors.Is(err, ErrNoToken)).To(BeTrue())
+		_, err = New("nope", "t", Options{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("sends the configured model, temperature and token budget to Anthropic", func() {
+		srv := serve(`{"content":[{"type":"tool_use","name":"select_tests","input":{"ids":["a"]}}]}`)
+		temp := 0.2
+		c, err := New(ProviderAnthropic, "", Options{BaseURL: srv.URL + "/v1/", Model: "claude-test", Temperature: &temp, MaxTokens: 300})
+		Expect(err).NotTo(HaveOccurred())
+		resp, err := c.ChatCompletion(context.Background(), Request{Prompt: "hi", Tool: testTool})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Text).To(MatchJSON(`{"ids":["a"]}`))
+		Expect(path).To(Equal("/v1/messages"))
+		Expect(received).To(HaveKeyWithValue("model", "claude-test"))
+		Expect(received).To(HaveKeyWithValue("temperature", 0.2))
+		Expect(received).To(HaveKeyWithValue("max_tokens", BeNumerically("==", 300)))
+	})
+
+	It("reaches OpenAI-compatible servers without a token", func() {
+		srv := serve(`{"choices":[{"message":{"role":"assistant","tool_calls":[{"type":"function","function":{"name":"select_tests","arguments":"{\"ids\":[\"a\"]}"}}]}}]}`)
+		c, err := New(ProviderOpenAI, "", Options{BaseURL: srv.URL + "/v1", Model: "llama3"})
+		Expect(err).NotTo(HaveOccurred())
+		resp, err := c.ChatCompletion(context.Background(), Request{Prompt: "hi", Tool: testTool})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Text).To(MatchJSON(`{"ids":["a"]}`))
+		Expect(path).To(Equal("/v1/chat/completions"))
+		Expect(received).To(HaveKeyWithValue("model", "llama3"))
+	})
+
+	It("constrains Gemini answers with a response schema", func() {
+		srv := serve(`{"candidates":[{"content":{"parts":[{"text":"{\"ids\":[\"a\"]}"}]}}]}`)
+		c, err := New(ProviderGemini, "key", Options{BaseURL: srv.URL})
+		Expect(err).NotTo(HaveOccurred())
+		resp, err := c.ChatCompletion(context.Background(), Request{Prompt: "hi", Tool: testTool})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Text).To(Equal(`{"ids":["a"]}`))
+		Expect(path).To(Equal("/models/gemini-pro:generateContent"))
+		Expect(received).To(HaveKeyWithValue("generationConfig", HaveKeyWithValue("responseSchema", HaveKeyWithValue("type", "OBJECT"))))
+	})
+
+	It("returns plain text when no tool is requested", func() {
+		srv := serve(`{"content":[{"type":"text","text":"advice"}]}`)
+		c, err := New(ProviderAnthropic, "t", Options{BaseURL: srv.URL})
+		Expect(err).NotTo(HaveOccurred())
+		resp, err := c.ChatCompletion(context.Background(), Request{Prompt: "hi"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Text).To(Equal("advice"))
+		Expect(received).NotTo(HaveKey("tools"))
+	})
+})
+
+var _ = Describe("errors and retries", func() {
+	var slept []time.Duration
+
+	newRetry := func(c Client, policy RetryPolicy) *retryClient {
+		slept = nil
+		return &retryClient{
+			next:   c,
+			policy: policy,
+			sleep: func(ctx context.Context, d time.Duration) error {
+				slept = append(slept, d)
+				return nil
+			},
+			jitter: func() float64 { return 1 },
+		}
+	}
+
+	It("types error responses instead of decoding them as empty answers", func() {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+		}))
+		defer srv.Close()
+		c := NewAnthropicClient("bad", Options{BaseURL: srv.URL}.WithDefaults(ProviderAnthropic))
+		_, err := c.ChatCompletion(context.Background(), Request{Prompt: "hi"})
+		Expect(errors.Is(err, ErrAuth)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("invalid x-api-key")))
+	})
+
+	It("retries rate limits and honours Retry-After", func() {
+		var calls int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.Header().Set("Retry-After", "7")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			fmt.Fprint(w, `{"candidates":[{"content":{"parts":[{"text":"ok"}]}}]}`)
+		}))
+		defer srv.Close()
+		c := newRetry(NewGeminiClient("k", Options{BaseURL: srv.URL}.WithDefaults(ProviderGemini)), DefaultRetryPolicy)
+		resp, err := c.ChatCompletion(context.Background(), Request{Prompt: "hi"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Text).To(Equal("ok"))
+		Expect(slept).To(Equal([]time.Duration{7 * time.Second}))
+	})
+
+	It("honours Retry-After from OpenAI up to MaxDelay", func() {
+		var calls int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.Header().Set("Retry-After", "3600")
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprint(w, `{"error":{"message":"slow down"}}`)
+				return
+			}
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`)
+		}))
+		defer srv.Close()
+		c := newRetry(NewOpenAIClient("k", Options{BaseURL: srv.URL}.WithDefaults(ProviderOpenAI)), DefaultRetryPolicy)
+		resp, err := c.ChatCompletion(context.Background(), Request{Prompt: "hi"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Text).To(Equal("ok"))
+		Expect(slept).To(Equal([]time.Duration{DefaultRetryPolicy.MaxDelay}))
+	})
+
+	It("backs off exponentially and gives up after MaxRetries", func() {
+		fake := clientFunc(func(ctx context.Context, req Request) (Response, error) {
+			return Response{}, newAPIError(ProviderOpenAI, 529, "overloaded", 0)
+		})
+		c := newRetry(fake, RetryPolicy{MaxRetries: 3, BaseDelay: time.Second, MaxDelay: 3 * time.Second})
+		_, err := c.ChatCompletion(context.Background(), Request{})
+		Expect(errors.Is(err, ErrOverloaded)).To(BeTrue())
+		Expect(slept).To(Equal([]time.Duration{time.Second, 2 * time.Second, 3 * time.Second}))
+	})
+
+	It("does not retry bad requests", func() {
+		fake := clientFunc(func(ctx context.Context, req Request) (Response, error) {
+			return Response{}, newAPIError(ProviderOpenAI, http.StatusBadRequest, "bad", 0)
+		})
+		c := newRetry(fake, DefaultRetryPolicy)
+		_, err := c.ChatCompletion(context.Background(), Request{})
+		Expect(errors.Is(err, ErrBadRequest)).To(BeTrue())
+		Expect(slept).To(BeEmpty())
+	})
+
+	It("bounds each attempt with the policy timeout", func() {
+		fake := clientFunc(func(ctx context.Context, req Request) (Response, error) {
+			<-ctx.Done()
+			return Response{}, ctx.Err()
+		})
+		c := newRetry(fake, RetryPolicy{MaxRetries: 1, Timeout: 10 * time.Millisecond})
+		_, err := c.ChatCompletion(context.Background(), Request{})
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		Expect(slept).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Cache", func() {
+	var (
+		cache *Cache
+		now   time.Time
+		calls int
+		next  Client
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		cache = NewCache(GinkgoT().TempDir(), time.Hour, 0)
+		cache.now = func() time.Time { return now }
+		calls = 0
+		next = clientFunc(func(ctx context.Context, req Request) (Response, error) {
+			calls++
+			return Response{Text: fmt.Sprintf("%s %d", req.Prompt, calls)}, nil
+		})
+	})
+
+	ask := func(c Client, prompt string) string {
+		resp, err := c.ChatCompletion(context.Background(), Request{Prompt: prompt})
+		Expect(err).NotTo(HaveOccurred())
+		return resp.Text
+	}
+
+	It("answers repeated calls from disk", func() {
+		c := cache.Wrap(next, ProviderOpenAI, Options{Model: "m"})
+		Expect(ask(c, "a")).To(Equal("a 1"))
+		Expect(ask(c, "a")).To(Equal("a 1"))
+		Expect(ask(cache.Wrap(next, ProviderOpenAI, Options{Model: "m"}), "a")).To(Equal("a 1"))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("keys entries by provider, model, options and prompt", func() {
+		temp := 0.7
+		ask(cache.Wrap(next, ProviderOpenAI, Options{Model: "m"}), "a")
+		ask(cache.Wrap(next, ProviderOpenAI, Options{Model: "m"}), "b")
+		ask(cache.Wrap(next, ProviderAnthropic, Options{Model: "m"}), "a")
+		ask(cache.Wrap(next, ProviderOpenAI, Options{Model: "n"}), "a")
+		ask(cache.Wrap(next, ProviderOpenAI, Options{Model: "m", Temperature: &temp}), "a")
+		ask(cache.Wrap(next, ProviderOpenAI, Options{Model: "m", CacheKey: "sample-2"}), "a")
+		Expect(calls).To(Equal(6))
+	})
+
+	It("expires entries after the TTL", func() {
+		c := cache.Wrap(next, ProviderOpenAI, Options{})
+		ask(c, "a")
+		now = now.Add(2 * time.Hour)
+		Expect(ask(c, "a")).To(Equal("a 2"))
+	})
+
+	It("evicts the least recently used entries beyond the size limit", func() {
+		c := cache.Wrap(next, ProviderOpenAI, Options{})
+		ask(c, "a")
+		stats, err := cache.Stats()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Entries).To(Equal(1))
+		cache.MaxBytes = 2 * stats.Bytes
+
+		now = now.Add(time.Minute)
+		ask(c, "b")
+		now = now.Add(time.Minute)
+		ask(c, "a")
+		now = now.Add(time.Minute)
+		ask(c, "c")
+		Expect(calls).To(Equal(3))
+		Expect(ask(c, "a")).To(Equal("a 1"))
+		Expect(ask(c, "b")).To(Equal("b 4"))
+	})
+
+	It("reports stats and clears", func() {
+		c := cache.Wrap(next, ProviderOpenAI, Options{})
+		ask(c, "a")
+		ask(c, "b")
+		stats, err := cache.Stats()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Entries).To(Equal(2))
+		Expect(stats.Oldest).To(BeTemporally("==", now))
+		Expect(cache.Clear()).To(Succeed())
+		stats, err = cache.Stats()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.Entries).To(BeZero())
+	})
+})
+
+var _ = Describe("Meter", func() {
+	usage := func(u Usage) Client {
+		return clientFunc(func(ctx context.Context, req Request) (Response, error) {
+			return Response{Text: "ok", Usage: u}, nil
+		})
+	}
+
+	It("reads usage from every provider", func() {
+		for p, reply := range map[Provider]string{
+			ProviderOpenAI:    `{"choices":[{"message":{"content":"a"}}],"usage":{"prompt_tokens":10,"completion_tokens":2}}`,
+			ProviderAnthropic: `{"content":[{"type":"text","text":"a"}],"usage":{"input_tokens":10,"output_tokens":2}}`,
+			ProviderGemini:    `{"candidates":[{"content":{"parts":[{"text":"a"}]}}],"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":2}}`,
+		} {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, reply) }))
+			c, err := New(p, "t", Options{BaseURL: srv.URL})
+			Expect(err).NotTo(HaveOccurred())
+			resp, err := c.ChatCompletion(context.Background(), Request{Prompt: "hi"})
+			srv.Close()
+			Expect(err).NotTo(HaveOccurred(), string(p))
+			Expect(resp.Usage).To(Equal(Usage{PromptTokens: 10, CompletionTokens: 2}), string(p))
+		}
+	})
+
+	It("prices usage per provider and model and prints a summary", func() {
+		m := NewMeter(map[string]Price{"gpt-4o": {Input: 5, Output: 15}}, 0)
+		c := m.Wrap(usage(Usage{PromptTokens: 1000, CompletionTokens: 100}), ProviderOpenAI, Options{Model: "gpt-4o-2024-05-13"})
+		c.ChatCompletion(context.Background(), Request{Prompt: "a"})
+		c.ChatCompletion(context.Background(), Request{Prompt: "b"})
+		Expect(m.Total()).To(BeNumerically("~", 0.013))
+		Expect(m.Lines()).To(Equal([]MeterLine{{
+			Provider: ProviderOpenAI, Model: "gpt-4o-2024-05-13", Calls: 2,
+			PromptTokens: 2000, CompletionTokens: 200, Cost: m.Total(), Priced: true,
+		}}))
+		var out strings.Builder
+		m.WriteSummary(&out)
+		Expect(out.String()).To(ContainSubstring("openai/gpt-4o-2024-05-13: 2 calls, 2000 prompt + 200 completion tokens, $0.0130"))
+	})
+
+	It("refuses calls whose worst case exceeds the budget", func() {
+		m := NewMeter(map[string]Price{"m": {Input: 1, Output: 1}}, 0.001)
+		c := m.Wrap(usage(Usage{PromptTokens: 100, CompletionTokens: 100}), ProviderOpenAI, Options{Model: "m", MaxTokens: 500})
+		_, err := c.ChatCompletion(context.Background(), Request{Prompt: "a"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = c.ChatCompletion(context.Background(), Request{Prompt: strings.Repeat("x", 4000)})
+		Expect(errors.Is(err, ErrBudgetExceeded)).To(BeTrue())
+		Expect(m.Lines()[0].Calls).To(Equal(1))
+	})
+
+	It("loads price overrides over the defaults", func() {
+		file := GinkgoT().TempDir() + "/prices.yaml"
+		os.WriteFile(file, []byte("openai/llama3: {input: 0, output: 0}\ngpt-4o: {input: 2.5, output: 10}\n"), 0o644)
+		prices, err := LoadPrices(file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(prices["gpt-4o"]).To(Equal(Price{Input: 2.5, Output: 10}))
+		Expect(prices).To(HaveKey("openai/llama3"))
+		Expect(prices).To(HaveKey("claude-3-opus"))
+	})
+})
+
+var _ = Describe("prompt filter", func() {
+	It("redacts prompts before sending and shows them as sent", func() {
+		var sent string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Messages []struct{ Content string } `json:"messages"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			sent = body.Messages[0].Content
+			fmt.Fprint(w, `{"content":[{"type":"text","text":"ok"}]}`)
+		}))
+		defer srv.Close()
+		var shown strings.Builder
+		c, err := New(ProviderAnthropic, "t", Options{
+			BaseURL:    srv.URL,
+			Redact:     func(s string) string { return strings.ReplaceAll(s, "hunter2", "[REDACTED]") },
+			ShowPrompt: &shown,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = c.ChatCompletion(context.Background(), Request{Prompt: "password hunter2"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sent).To(Equal("password [REDACTED]"))
+		Expect(shown.String()).To(ContainSubstring("password [REDACTED]"))
+		Expect(shown.String()).NotTo(ContainSubstring("hunter2"))
+	})
+})
+
+var _ = Describe("record and replay", func() {
+	It("records exchanges without credentials and replays them offline", func() {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"candidates":[{"content":{"parts":[{"text":"recorded"}]}}]}`)
+		}))
+		dir := GinkgoT().TempDir()
+		recorder, err := NewRecorder(dir, nil)
+		Expect(err).NotTo(HaveOccurred())
+		opts := Options{BaseURL: srv.URL, HTTPClient: &http.Client{Transport: recorder}}
+		c, err := New(ProviderGemini, "secret-key", opts)
+		Expect(err).NotTo(HaveOccurred())
+		resp, err := c.ChatCompletion(context.Background(), Request{Prompt: "which tests?"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Text).To(Equal("recorded"))
+		srv.Close()
+
+		files, err := fixtures(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		data, err := os.ReadFile(files[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring("which tests?"))
+		Expect(string(data)).NotTo(ContainSubstring("secret-key"))
+
+		replayer, err := NewReplayer(dir)
+		Expect(err).NotTo(HaveOccurred())
+		opts.HTTPClient = &http.Client{Transport: replayer}
+		c, err = New(ProviderGemini, "other-key", opts)
+		Expect(err).NotTo(HaveOccurred())
+		resp, err = c.ChatCompletion(context.Background(), Request{Prompt: "which tests?"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Text).To(Equal("recorded"))
+
+		_, err = c.ChatCompletion(context.Background(), Request{Prompt: "something else"})
+		Expect(errors.Is(err, ErrNoRecording)).To(BeTrue())
+	})
+
+	It("refuses to replay an empty directory", func() {
+		_, err := NewReplayer(GinkgoT().TempDir())
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+type clientFunc func(ctx context.Context, req Request) (Response, error)
+
+func (f clientFunc) ChatCompletion(ctx context.Context, req Request) (Response, error) {
+	return f(ctx, req)
+}
+
+func TestLLM(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "LLM Suite")
+}
@@ -0,0 +1,243 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrBudgetExceeded is returned for calls that could push the run past its
+// cost limit. Such calls are never sent.
+var ErrBudgetExceeded = errors.New("LLM cost budget exceeded")
+
+// Price is the cost of a model in US dollars per million tokens.
+type Price struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// DefaultPrices are list prices keyed by model name. A key also prices
+// every model it is a prefix of, e.g. gpt-4o prices gpt-4o-2024-05-13.
+var DefaultPrices = map[string]Price{
+	"gpt-3.5-turbo":     {Input: 0.50, Output: 1.50},
+	"gpt-4o":            {Input: 5, Output: 15},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.60},
+	"gpt-4-turbo":       {Input: 10, Output: 30},
+	"claude-3-opus":     {Input: 15, Output: 75},
+	"claude-3-5-sonnet": {Input: 3, Output: 15},
+	"claude-3-sonnet":   {Input: 3, Output: 15},
+	"claude-3-haiku":    {Input: 0.25, Output: 1.25},
+	"gemini-pro":        {Input: 0.50, Output: 1.50},
+	"gemini-1.5-pro":    {Input: 3.50, Output: 10.50},
+	"gemini-1.5-flash":  {Input: 0.35, Output: 1.05},
+}
+
+// LoadPrices returns DefaultPrices overridden by the YAML price table at
+// path, which maps model names, or provider/model, to input and output
+// prices. A missing file leaves the defaults.
+func LoadPrices(path string) (map[string]Price, error) {
+	prices := make(map[string]Price, len(DefaultPrices))
+	for k, v := range DefaultPrices {
+		prices[k] = v
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return prices, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var custom map[string]Price
+	if err := yaml.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for k, v := range custom {
+		prices[k] = v
+	}
+	return prices, nil
+}
+
+// Meter records token usage and cost across clients and enforces a budget.
+type Meter struct {
+	Prices map[string]Price
+	// MaxCost refuses calls whose worst-case cost would exceed it, in US
+	// dollars. Zero means no limit.
+	MaxCost float64
+
+	mu       sync.Mutex
+	spent    float64
+	reserved float64
+	lines    map[string]*MeterLine
+}
+
+// MeterLine sums the usage of one provider and model.
+type MeterLine struct {
+	Provider         Provider
+	Model            string
+	Calls            int
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+	// Priced is false when no price is known for the model.
+	Priced bool
+	// Estimated is set when the provider reported no usage and tokens were
+	// estimated from the text.
+	Estimated bool
+}
+
+// NewMeter returns a meter using prices and maxCost.
+func NewMeter(prices map[string]Price, maxCost float64) *Meter {
+	return &Meter{Prices: prices, MaxCost: maxCost, lines: map[string]*MeterLine{}}
+}
+
+// Wrap returns a client whose calls are metered. opts must have the
+// provider defaults applied.
+func (m *Meter) Wrap(next Client, provider Provider, opts Options) Client {
+	price, priced := m.price(provider, opts.Model)
+	return &meterClient{next: next, meter: m, provider: provider, model: opts.Model, maxTokens: opts.MaxTokens, price: price, priced: priced}
+}
+
+// price looks up provider/model, then the longest model name prefix.
+func (m *Meter) price(provider Provider, model string) (Price, bool) {
+	if p, ok := m.Prices[string(provider)+"/"+model]; ok {
+		return p, true
+	}
+	best := ""
+	for k := range m.Prices {
+		if strings.HasPrefix(model, k) && len(k) > len(best) {
+			best = k
+		}
+	}
+	p, ok := m.Prices[best]
+	return p, ok && best != ""
+}
+
+type meterClient struct {
+	next      Client
+	meter     *Meter
+	provider  Provider
+	model     string
+	maxTokens int
+	price     Price
+	priced    bool
+}
+
+func (c *meterClient) ChatCompletion(ctx context.Context, req Request) (Response, error) {
+	estimate := c.cost(Usage{PromptTokens: promptTokens(req), CompletionTokens: c.maxTokens})
+	if err := c.meter.reserve(estimate); err != nil {
+		return Response{}, fmt.Errorf("%s/%s: %w", c.provider, c.model, err)
+	}
+	resp, err := c.next.ChatCompletion(ctx, req)
+	usage, estimated := resp.Usage, false
+	if err == nil && usage == (Usage{}) {
+		usage = Usage{PromptTokens: promptTokens(req), CompletionTokens: estimateTokens(resp.Text)}
+		estimated = true
+	}
+	c.meter.record(c.provider, c.model, usage, c.cost(usage), estimate, c.priced, estimated, err == nil)
+	return resp, err
+}
+
+func (c *meterClient) cost(u Usage) float64 {
+	return (float64(u.PromptTokens)*c.price.Input + float64(u.CompletionTokens)*c.price.Output) / 1e6
+}
+
+// reserve claims the worst-case cost of a call before it is sent, so that
+// concurrent calls cannot overshoot the budget together.
+func (m *Meter) reserve(estimate float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.MaxCost > 0 && m.spent+m.reserved+estimate > m.MaxCost {
+		return fmt.Errorf("%w: call may cost $%.4f with $%.4f of $%.2f used", ErrBudgetExceeded, estimate, m.spent+m.reserved, m.MaxCost)
+	}
+	m.reserved += estimate
+	return nil
+}
+
+func (m *Meter) record(provider Provider, model string, u Usage, cost, reserved float64, priced, estimated, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reserved -= reserved
+	if !ok {
+		return
+	}
+	key := string(provider) + "/" + model
+	l := m.lines[key]
+	if l == nil {
+		l = &MeterLine{Provider: provider, Model: model, Priced: priced}
+		m.lines[key] = l
+	}
+	l.Calls++
+	l.PromptTokens += u.PromptTokens
+	l.CompletionTokens += u.CompletionTokens
+	l.Cost += cost
+	l.Estimated = l.Estimated || estimated
+	m.spent += cost
+}
+
+// Lines returns the usage per provider and model in name order.
+func (m *Meter) Lines() []MeterLine {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []MeterLine
+	for _, l := range m.lines {
+		out = append(out, *l)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return string(out[i].Provider)+"/"+out[i].Model < string(out[j].Provider)+"/"+out[j].Model
+	})
+	return out
+}
+
+// Total returns the cost of all recorded calls.
+func (m *Meter) Total() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.spent
+}
+
+// WriteSummary prints the usage table. Nothing is printed when no call was
+// made.
+func (m *Meter) WriteSummary(w io.Writer) {
+	lines := m.Lines()
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "LLM usage:")
+	for _, l := range lines {
+		cost := fmt.Sprintf("$%.4f", l.Cost)
+		if !l.Priced {
+			cost = "no price"
+		}
+		note := ""
+		if l.Estimated {
+			note = " (estimated)"
+		}
+		fmt.Fprintf(w, "  %s/%s: %d calls, %d prompt + %d completion tokens%s, %s\n",
+			l.Provider, l.Model, l.Calls, l.PromptTokens, l.CompletionTokens, note, cost)
+	}
+	fmt.Fprintf(w, "  total: $%.4f\n", m.Total())
+}
+
+// promptTokens estimates the input tokens of req, including the tool schema.
+func promptTokens(req Request) int {
+	n := estimateTokens(req.Prompt)
+	if req.Tool != nil {
+		schema, _ := json.Marshal(req.Tool)
+		n += estimateTokens(string(schema))
+	}
+	return n
+}
+
+// estimateTokens approximates the token count of s at four characters per
+// token.
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
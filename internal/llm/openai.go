@@ -0,0 +1,111 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func init() {
+	Register(ProviderOpenAI, Options{
+		Model:         openai.GPT3Dot5Turbo,
+		BaseURL:       "https://api.openai.com/v1",
+		MaxTokens:     4096,
+		ContextTokens: 16385,
+	}, func(token string, opts Options) (Client, error) {
+		return NewOpenAIClient(token, opts), nil
+	})
+}
+
+// OpenAIClient implements Client using the OpenAI chat completions API or any
+// server compatible with it.
+type OpenAIClient struct {
+	client *openai.Client
+	opts   Options
+}
+
+// NewOpenAIClient returns a Client for OpenAI. opts should already have the
+// provider defaults applied.
+func NewOpenAIClient(token string, opts Options) *OpenAIClient {
+	cfg := openai.DefaultConfig(token)
+	cfg.BaseURL = opts.BaseURL
+	hc := http.Client{}
+	if opts.HTTPClient != nil {
+		hc = *opts.HTTPClient
+	}
+	next := hc.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	hc.Transport = hintTransport{next: next}
+	cfg.HTTPClient = &hc
+	return &OpenAIClient{client: openai.NewClientWithConfig(cfg), opts: opts}
+}
+
+// retryAfterKey carries a *string through the request context for
+// hintTransport to fill in, since go-openai errors drop response headers.
+type retryAfterKey struct{}
+
+// hintTransport records the Retry-After header of failed responses.
+type hintTransport struct {
+	next http.RoundTripper
+}
+
+func (t hintTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if hint, ok := req.Context().Value(retryAfterKey{}).(*string); ok && err == nil && resp.StatusCode >= 300 {
+		*hint = resp.Header.Get("Retry-After")
+	}
+	return resp, err
+}
+
+// ChatCompletion sends the prompt and returns the assistant message or the
+// requested tool call arguments.
+func (c *OpenAIClient) ChatCompletion(ctx context.Context, r Request) (Response, error) {
+	req := openai.ChatCompletionRequest{
+		Model:     c.opts.Model,
+		MaxTokens: c.opts.MaxTokens,
+		Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: r.Prompt}},
+	}
+	if t := c.opts.Temperature; t != nil {
+		// go-openai omits a zero temperature, so send the smallest positive
+		// value instead to keep sampling deterministic.
+		req.Temperature = float32(max(*t, math.SmallestNonzeroFloat32))
+	}
+	if r.Tool != nil {
+		req.Tools = []openai.Tool{{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        r.Tool.Name,
+				Description: r.Tool.Description,
+				Parameters:  r.Tool.Schema,
+			},
+		}}
+		req.ToolChoice = openai.ToolChoice{
+			Type:     openai.ToolTypeFunction,
+			Function: openai.ToolFunction{Name: r.Tool.Name},
+		}
+	}
+	var hint string
+	resp, err := c.client.CreateChatCompletion(context.WithValue(ctx, retryAfterKey{}, &hint), req)
+	if err != nil {
+		return Response{}, openAIError(err, retryAfter(hint))
+	}
+	if len(resp.Choices) == 0 {
+		return Response{}, errors.New("no choices returned")
+	}
+	msg := resp.Choices[0].Message
+	usage := Usage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}
+	if r.Tool == nil {
+		return Response{Text: msg.Content, Usage: usage}, nil
+	}
+	for _, call := range msg.ToolCalls {
+		if call.Function.Name == r.Tool.Name {
+			return Response{Text: call.Function.Arguments, Usage: usage}, nil
+		}
+	}
+	return Response{}, errors.New("model did not call " + r.Tool.Name)
+}
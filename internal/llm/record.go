@@ -0,0 +1,253 @@
+package llm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNoRecording is returned in replay mode for a request that was never
+// recorded.
+var ErrNoRecording = errors.New("no recorded response")
+
+// Exchange is one recorded provider request and its response. Credentials
+// are removed before it is written, so fixtures can be attached to bug
+// reports.
+type Exchange struct {
+	// Key identifies the request during replay.
+	Key      string           `json:"key"`
+	Request  RecordedMessage  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedMessage is a request or response body with its headers. JSON
+// bodies are kept as JSON; anything else is stored in Text.
+type RecordedMessage struct {
+	Method string          `json:"method,omitempty"`
+	URL    string          `json:"url,omitempty"`
+	Header http.Header     `json:"header,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Text   string          `json:"text,omitempty"`
+}
+
+// RecordedResponse is a recorded HTTP response.
+type RecordedResponse struct {
+	Status int `json:"status"`
+	RecordedMessage
+}
+
+// secretHeaders and secretParams carry credentials and are never recorded.
+var (
+	secretHeaders = []string{"Authorization", "X-Api-Key", "Api-Key", "X-Goog-Api-Key"}
+	secretParams  = []string{"key"}
+)
+
+// NewRecorder returns a transport that sends requests through next and
+// writes every exchange to dir as a JSON fixture.
+func NewRecorder(dir string, next http.RoundTripper) (http.RoundTripper, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	existing, err := fixtures(dir)
+	if err != nil {
+		return nil, err
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &recorder{dir: dir, next: next, seq: len(existing)}, nil
+}
+
+type recorder struct {
+	dir  string
+	next http.RoundTripper
+
+	mu  sync.Mutex
+	seq int
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	ex := Exchange{
+		Key:     requestKey(req, reqBody),
+		Request: message(sanitizeHeader(req.Header), reqBody),
+		Response: RecordedResponse{
+			Status:          resp.StatusCode,
+			RecordedMessage: message(resp.Header, respBody),
+		},
+	}
+	ex.Request.Method = req.Method
+	ex.Request.URL = sanitizeURL(req.URL).String()
+	data, err := json.MarshalIndent(ex, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.seq++
+	name := fmt.Sprintf("%04d-%s.json", r.seq, ex.Key[:12])
+	r.mu.Unlock()
+	if err := os.WriteFile(filepath.Join(r.dir, name), data, 0o644); err != nil {
+		return nil, fmt.Errorf("record %s: %w", name, err)
+	}
+	return resp, nil
+}
+
+// NewReplayer returns a transport that answers requests from the fixtures
+// in dir without touching the network. Identical requests are answered in
+// the order they were recorded.
+func NewReplayer(dir string) (http.RoundTripper, error) {
+	files, err := fixtures(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recordings in %s", dir)
+	}
+	r := &replayer{exchanges: map[string][]Exchange{}}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var ex Exchange
+		if err := json.Unmarshal(data, &ex); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		r.exchanges[ex.Key] = append(r.exchanges[ex.Key], ex)
+	}
+	return r, nil
+}
+
+type replayer struct {
+	mu        sync.Mutex
+	exchanges map[string][]Exchange
+}
+
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	key := requestKey(req, body)
+
+	r.mu.Lock()
+	queue := r.exchanges[key]
+	if len(queue) == 0 {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("%w for %s %s (key %s)", ErrNoRecording, req.Method, sanitizeURL(req.URL), key[:12])
+	}
+	ex := queue[0]
+	// The last answer is kept so extra identical requests still succeed.
+	if len(queue) > 1 {
+		r.exchanges[key] = queue[1:]
+	}
+	r.mu.Unlock()
+
+	respBody := []byte(ex.Response.Body)
+	if ex.Response.Body == nil {
+		respBody = []byte(ex.Response.Text)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", ex.Response.Status, http.StatusText(ex.Response.Status)),
+		StatusCode:    ex.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        ex.Response.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+// fixtures lists the recorded exchanges in dir in recording order.
+func fixtures(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	sort.Strings(files)
+	return files, err
+}
+
+// readBody drains *body and replaces it with a copy that can be read again.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// requestKey hashes the method, the URL without credentials and the body.
+func requestKey(req *http.Request, body []byte) string {
+	var compact bytes.Buffer
+	if json.Compact(&compact, body) != nil {
+		compact.Reset()
+		compact.Write(body)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, sanitizeURL(req.URL))
+	h.Write(compact.Bytes())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func message(header http.Header, body []byte) RecordedMessage {
+	m := RecordedMessage{Header: header}
+	if json.Valid(body) {
+		m.Body = body
+	} else {
+		m.Text = string(body)
+	}
+	return m
+}
+
+func sanitizeURL(u *url.URL) *url.URL {
+	out := *u
+	q := out.Query()
+	for _, p := range secretParams {
+		q.Del(p)
+	}
+	out.RawQuery = q.Encode()
+	out.User = nil
+	return &out
+}
+
+func sanitizeHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for _, k := range secretHeaders {
+		out.Del(k)
+	}
+	for k := range out {
+		if strings.Contains(strings.ToLower(k), "token") {
+			out.Del(k)
+		}
+	}
+	return out
+}
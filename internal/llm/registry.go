@@ -0,0 +1,67 @@
+package llm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Factory creates a client from a token and options that already have the
+// provider defaults applied.
+type Factory func(token string, opts Options) (Client, error)
+
+type registration struct {
+	defaults Options
+	factory  Factory
+}
+
+var registry = map[Provider]registration{}
+
+// Register makes a provider available to New. It is meant to be called from
+// init functions and panics on duplicate registrations.
+func Register(provider Provider, defaults Options, factory Factory) {
+	if _, ok := registry[provider]; ok {
+		panic("llm: provider registered twice: " + string(provider))
+	}
+	registry[provider] = registration{defaults: defaults, factory: factory}
+}
+
+// Providers lists the registered providers in name order.
+func Providers() []Provider {
+	var out []Provider
+	for p := range registry {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// New returns a client for provider that retries failed calls according to
+// opts.Retry, meters calls that reach the provider in opts.Meter and, when
+// opts.Cache is set, answers repeated calls from it. Prompts pass through
+// opts.Redact before anything else sees them. A token is required unless a
+// custom base URL is given, since self-hosted servers usually need none.
+func New(provider Provider, token string, opts Options) (Client, error) {
+	reg, ok := registry[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider %q", provider)
+	}
+	if token == "" && opts.BaseURL == "" {
+		return nil, fmt.Errorf("%s: %w", provider, ErrNoToken)
+	}
+	opts = opts.WithDefaults(provider)
+	c, err := reg.factory(token, opts)
+	if err != nil {
+		return nil, err
+	}
+	c = WithRetry(c, *opts.Retry)
+	if opts.Meter != nil {
+		c = opts.Meter.Wrap(c, provider, opts)
+	}
+	if opts.Cache != nil {
+		c = opts.Cache.Wrap(c, provider, opts)
+	}
+	if opts.Redact != nil || opts.ShowPrompt != nil {
+		c = &promptFilter{next: c, provider: provider, model: opts.Model, redact: opts.Redact, show: opts.ShowPrompt}
+	}
+	return c, nil
+}
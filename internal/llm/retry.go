@@ -0,0 +1,114 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy controls how failed calls are retried.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+	// BaseDelay is the backoff before the first retry; it doubles after
+	// every attempt up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Timeout bounds each individual attempt. Zero means no limit beyond
+	// the caller's context.
+	Timeout time.Duration
+}
+
+// DefaultRetryPolicy is used when Options.Retry is nil.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  time.Second,
+	MaxDelay:   30 * time.Second,
+	Timeout:    60 * time.Second,
+}
+
+// WithRetry wraps c so that rate limits, overloads, server errors and
+// timeouts are retried with exponential backoff and full jitter. A
+// Retry-After hint from the provider takes precedence over the backoff but
+// is capped at MaxDelay.
+func WithRetry(c Client, policy RetryPolicy) Client {
+	return &retryClient{next: c, policy: policy, sleep: sleepContext, jitter: rand.Float64}
+}
+
+type retryClient struct {
+	next   Client
+	policy RetryPolicy
+	sleep  func(ctx context.Context, d time.Duration) error
+	jitter func() float64
+}
+
+func (r *retryClient) ChatCompletion(ctx context.Context, req Request) (Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := r.attempt(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt >= r.policy.MaxRetries || ctx.Err() != nil || !retryable(err) {
+			return Response{}, err
+		}
+		delay := r.backoff(attempt, err)
+		log.Printf("llm: %v; retrying in %s (attempt %d/%d)", err, delay.Round(time.Millisecond), attempt+2, r.policy.MaxRetries+1)
+		if err := r.sleep(ctx, delay); err != nil {
+			return Response{}, err
+		}
+	}
+}
+
+func (r *retryClient) attempt(ctx context.Context, req Request) (Response, error) {
+	if r.policy.Timeout <= 0 {
+		return r.next.ChatCompletion(ctx, req)
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.policy.Timeout)
+	defer cancel()
+	return r.next.ChatCompletion(ctx, req)
+}
+
+func (r *retryClient) backoff(attempt int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if r.policy.MaxDelay > 0 {
+			return min(apiErr.RetryAfter, r.policy.MaxDelay)
+		}
+		return apiErr.RetryAfter
+	}
+	d := r.policy.BaseDelay << attempt
+	if d <= 0 || (r.policy.MaxDelay > 0 && d > r.policy.MaxDelay) {
+		d = r.policy.MaxDelay
+	}
+	return time.Duration(r.jitter() * float64(d))
+}
+
+// retryable reports whether err is worth another attempt.
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	if errors.Is(err, ErrNoRecording) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,75 @@
+package llmselector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// Strategy is a named Selector taking part in a fallback chain.
+type Strategy struct {
+	Name     string
+	Selector Selector
+}
+
+// Chain tries each strategy in order and returns the first selection that
+// succeeds, e.g. anthropic -> openai -> static -> all.
+type Chain struct {
+	Strategies []Strategy
+	// Strict returns the first strategy's error instead of falling back.
+	Strict bool
+}
+
+// Select runs the strategies in order. Each selection is stamped with the
+// name of the strategy that produced it.
+func (c Chain) Select(ctx context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]Selection, error) {
+	var errs []error
+	for i, s := range c.Strategies {
+		selected, err := s.Selector.Select(ctx, changes, tests)
+		if err != nil {
+			if c.Strict {
+				return nil, fmt.Errorf("%s: %w", s.Name, err)
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+			if i+1 < len(c.Strategies) {
+				log.Printf("selection: strategy %s failed: %v; falling back to %s", s.Name, err, c.Strategies[i+1].Name)
+			}
+			continue
+		}
+		for i := range selected {
+			if selected[i].Source == "" {
+				selected[i].Source = s.Name
+			}
+		}
+		log.Printf("selection: strategy %s selected %d of %d tests", s.Name, len(selected), len(tests))
+		return selected, nil
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no selection strategies configured")
+	}
+	return nil, fmt.Errorf("all selection strategies failed: %w", errors.Join(errs...))
+}
+
+// Unavailable returns a Selector that always fails with err. It stands in
+// for a strategy that could not be constructed, so the chain can report it.
+func Unavailable(err error) Selector {
+	return unavailable{err: err}
+}
+
+type unavailable struct{ err error }
+
+func (u unavailable) Select(context.Context, []diff.Change, []testmeta.Metadata) ([]Selection, error) {
+	return nil, u.err
+}
+
+// All selects every test.
+type All struct{}
+
+// Select returns every test.
+func (All) Select(_ context.Context, _ []diff.Change, tests []testmeta.Metadata) ([]Selection, error) {
+	return selectAll(tests, "all tests"), nil
+}
@@ -0,0 +1,187 @@
+package llmselector
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/llm"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// completeFunc sends a single prompt to a provider and returns the
+// structured answer the model produced for tool.
+type completeFunc func(ctx context.Context, prompt string, tool llm.Tool) (string, error)
+
+// answerOverhead approximates the tokens an answer spends on reason,
+// confidence and JSON punctuation in addition to the test ID.
+const answerOverhead = 32
+
+// estimateTokens approximates the token count of s. Roughly four characters
+// per token holds well enough for English text and Go identifiers.
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// batchTests splits tests into batches whose prompts fit within the context
+// window and whose worst-case answers fit within the completion budget.
+func batchTests(changes []diff.Change, tests []testmeta.Metadata, opts Options) ([][]testmeta.Metadata, error) {
+	base, err := buildPrompt(changes, nil)
+	if err != nil {
+		return nil, err
+	}
+	fixed := estimateTokens(base)
+	inputBudget := opts.ContextTokens - opts.MaxTokens - fixed
+	if inputBudget <= 0 {
+		return nil, fmt.Errorf("changes need about %d tokens, which does not fit a %d token context", fixed, opts.ContextTokens)
+	}
+
+	var batches [][]testmeta.Metadata
+	var cur []testmeta.Metadata
+	in, out := 0, 0
+	for _, t := range tests {
+		inCost := estimateTokens(testLine(t))
+		outCost := estimateTokens(t.ID()) + answerOverhead
+		if len(cur) > 0 && (in+inCost > inputBudget || out+outCost > opts.MaxTokens) {
+			batches = append(batches, cur)
+			cur, in, out = nil, 0, 0
+		}
+		cur = append(cur, t)
+		in += inCost
+		out += outCost
+	}
+	if len(cur) > 0 {
+		batches = append(batches, cur)
+	}
+	return batches, nil
+}
+
+// selectTests picks the selection strategy configured in opts.
+func selectTests(ctx context.Context, complete completeFunc, changes []diff.Change, tests []testmeta.Metadata, opts Options) ([]Selection, error) {
+	if opts.Hierarchical {
+		return selectHierarchical(ctx, complete, changes, tests, opts)
+	}
+	return selectChunked(ctx, complete, changes, tests, opts)
+}
+
+// selectChunked queries each batch of tests concurrently and merges the
+// answers. If the model selects nothing at all every test is returned.
+func selectChunked(ctx context.Context, complete completeFunc, changes []diff.Change, tests []testmeta.Metadata, opts Options) ([]Selection, error) {
+	batches, err := batchTests(changes, tests, opts)
+	if err != nil {
+		return nil, err
+	}
+	results, err := runBatches(ctx, complete, changes, batches, opts)
+	if err != nil {
+		return nil, err
+	}
+	return mergeSelections(results, tests, opts)
+}
+
+// runBatches queries every batch with at most opts.Concurrency requests in
+// flight. The first failure cancels the remaining requests.
+func runBatches(ctx context.Context, complete completeFunc, changes []diff.Change, batches [][]testmeta.Metadata, opts Options) ([][]Selection, error) {
+	results := make([][]Selection, len(batches))
+	err := forEach(ctx, len(batches), opts.Concurrency, func(ctx context.Context, i int) error {
+		p, err := buildPrompt(changes, batches[i])
+		if err != nil {
+			return err
+		}
+		content, err := complete(ctx, p, selectTestsTool)
+		if err != nil {
+			return fmt.Errorf("batch %d/%d: %w", i+1, len(batches), err)
+		}
+		answers, err := parseAnswers(content)
+		if err == nil {
+			err = checkAnswers(answers, len(batches[i]), func(id string) bool { return len(matchTests([]answer{{ID: id}}, batches[i])) > 0 })
+		}
+		if err != nil {
+			return fmt.Errorf("batch %d/%d: %w", i+1, len(batches), err)
+		}
+		results[i] = matchTests(answers, batches[i])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// forEach calls fn for every index below n with at most limit calls in
+// flight. The first error cancels the context passed to the remaining calls
+// and is returned once all of them have finished.
+func forEach(ctx context.Context, n, limit int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once     sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	sem := make(chan struct{}, max(limit, 1))
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				fail(ctx.Err())
+				return
+			}
+			defer func() { <-sem }()
+			if err := fn(ctx, i); err != nil {
+				fail(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// mergeSelections unions the per-batch selections, keeping the first answer
+// for each test, and drops those below opts.MinConfidence. Every test is
+// returned when nothing is left, whether the model selected nothing or only
+// low-confidence tests, and more than opts.MaxSelections is rejected as
+// suspicious.
+func mergeSelections(results [][]Selection, all []testmeta.Metadata, opts Options) ([]Selection, error) {
+	seen := map[testmeta.Metadata]bool{}
+	var merged []Selection
+	for _, r := range results {
+		for _, s := range r {
+			if seen[s.Test] {
+				continue
+			}
+			seen[s.Test] = true
+			merged = append(merged, s)
+		}
+	}
+	if len(merged) == 0 {
+		log.Printf("selection: model selected no tests; running all %d", len(all))
+		return selectAll(all, "model selected no tests"), nil
+	}
+	if opts.MaxSelections > 0 && len(merged) > opts.MaxSelections {
+		return nil, suspicious(fmt.Sprintf("%d selections exceed the cap of %d", len(merged), opts.MaxSelections))
+	}
+	kept := merged[:0]
+	for _, s := range merged {
+		if s.Confidence >= opts.MinConfidence {
+			kept = append(kept, s)
+		}
+	}
+	if len(kept) == 0 {
+		log.Printf("selection: no selected test reaches confidence %.2f; running all %d", opts.MinConfidence, len(all))
+		return selectAll(all, "no selection reached the minimum confidence"), nil
+	}
+	return kept, nil
+}
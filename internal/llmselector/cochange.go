@@ -0,0 +1,115 @@
+package llmselector
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// CoChange selects tests whose files historically changed in the same
+// commits as the changed source files. The rule "source -> test file" needs
+// Support commits touching both and a Confidence share of the commits
+// touching the source. It finds tests the import graph misses, e.g. for
+// reflection-heavy code.
+type CoChange struct {
+	// Support is the least number of commits changing both files.
+	Support int
+	// Confidence is the least share, between 0 and 1, of the commits
+	// changing the source file that also changed the test file.
+	Confidence float64
+	// Commits is how many recent commits are mined. Zero mines all.
+	Commits int
+	// MaxFiles ignores commits touching more files, such as mass renames,
+	// which relate everything to everything. Zero keeps all.
+	MaxFiles int
+
+	commitFiles func(rev string, limit int) ([][]string, error)
+}
+
+// rule is an association between a changed source file and a test file.
+type rule struct {
+	source     string
+	both, seen int
+}
+
+func (r rule) confidence() float64 {
+	return float64(r.both) / float64(r.seen)
+}
+
+// Select mines the history and selects the tests of every test file with a
+// rule from a changed source file. Confidence is that of the strongest rule.
+// It fails with ErrNoSignal when no rule selects a test.
+func (c CoChange) Select(ctx context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]Selection, error) {
+	sources := map[string]bool{}
+	for _, ch := range changes {
+		if f := filepath.ToSlash(ch.File); !strings.HasSuffix(f, "_test.go") {
+			sources[f] = true
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%w: no source files changed", ErrNoSignal)
+	}
+	mine := c.commitFiles
+	if mine == nil {
+		mine = diff.CommitFiles
+	}
+	commits, err := mine(snapshotFrom(ctx).Rev, c.Commits)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]int{}
+	both := map[[2]string]int{}
+	for _, files := range commits {
+		if c.MaxFiles > 0 && len(files) > c.MaxFiles {
+			continue
+		}
+		var changedSources, testFiles []string
+		for _, f := range files {
+			switch {
+			case sources[f]:
+				changedSources = append(changedSources, f)
+			case strings.HasSuffix(f, "_test.go"):
+				testFiles = append(testFiles, f)
+			}
+		}
+		for _, s := range changedSources {
+			seen[s]++
+			for _, t := range testFiles {
+				both[[2]string{s, t}]++
+			}
+		}
+	}
+
+	best := map[string]rule{}
+	for key, n := range both {
+		r := rule{source: key[0], both: n, seen: seen[key[0]]}
+		if n < c.Support || r.confidence() < c.Confidence {
+			continue
+		}
+		if prev, ok := best[key[1]]; !ok || r.confidence() > prev.confidence() ||
+			r.confidence() == prev.confidence() && r.both > prev.both {
+			best[key[1]] = r
+		}
+	}
+
+	var selected []Selection
+	for _, t := range tests {
+		r, ok := best[filepath.ToSlash(t.File)]
+		if !ok {
+			continue
+		}
+		reason := fmt.Sprintf("%s changed with %s in %d of %d commits", filepath.ToSlash(t.File), r.source, r.both, r.seen)
+		selected = append(selected, Selection{Test: t, Reason: reason, Confidence: r.confidence()})
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w: no co-change rule reaches a test", ErrNoSignal)
+	}
+	sort.SliceStable(selected, func(i, j int) bool { return selected[i].Confidence > selected[j].Confidence })
+	return selected, nil
+}
@@ -0,0 +1,143 @@
+package llmselector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// Ways an Ensemble combines the answers of its members.
+const (
+	VoteUnion        = "union"
+	VoteIntersection = "intersection"
+	VoteMajority     = "majority"
+)
+
+// Ensemble asks several selectors in parallel and combines their answers.
+// Members may be different providers or the same provider sampled several
+// times at a nonzero temperature (self-consistency).
+type Ensemble struct {
+	Members []Strategy
+	// Vote is VoteUnion, VoteIntersection or VoteMajority (the default).
+	Vote string
+	// Quorum is the number of votes a test needs under VoteMajority. It
+	// defaults to more than half of the members that answered.
+	Quorum int
+}
+
+// Select queries every member and keeps the tests that pass the vote. Each
+// selection records how many members voted for it in Votes and Voters; tests
+// the members disagree on are logged. When members selected tests but none
+// passes the vote, as when an intersection is empty, the union runs instead.
+func (e Ensemble) Select(ctx context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]Selection, error) {
+	if len(e.Members) == 0 {
+		return nil, errors.New("ensemble has no members")
+	}
+	results := make([][]Selection, len(e.Members))
+	errs := make([]error, len(e.Members))
+	forEach(ctx, len(e.Members), len(e.Members), func(ctx context.Context, i int) error {
+		results[i], errs[i] = e.Members[i].Selector.Select(ctx, changes, tests)
+		return nil
+	})
+
+	var answered [][]Selection
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("ensemble: member %s failed: %v", e.Members[i].Name, err)
+			failed = append(failed, fmt.Errorf("%s: %w", e.Members[i].Name, err))
+			continue
+		}
+		answered = append(answered, results[i])
+	}
+	if len(answered) == 0 {
+		return nil, fmt.Errorf("every ensemble member failed: %w", errors.Join(failed...))
+	}
+
+	need, err := e.votesNeeded(len(answered))
+	if err != nil {
+		return nil, err
+	}
+	selected, disputed := tally(answered, tests, need)
+	if len(disputed) > 0 {
+		log.Printf("ensemble: members disagree on %d tests: %s", len(disputed), strings.Join(disputed, ", "))
+	}
+	if len(selected) == 0 && len(disputed) > 0 {
+		log.Printf("ensemble: no test has %d votes; running the union of %d", need, len(disputed))
+		selected, _ = tally(answered, tests, 1)
+	}
+	return selected, nil
+}
+
+// votesNeeded returns how many of voters must select a test for it to run.
+func (e Ensemble) votesNeeded(voters int) (int, error) {
+	switch e.Vote {
+	case VoteUnion:
+		return 1, nil
+	case VoteIntersection:
+		return voters, nil
+	case VoteMajority, "":
+		if e.Quorum > 0 {
+			return min(e.Quorum, voters), nil
+		}
+		return voters/2 + 1, nil
+	default:
+		return 0, fmt.Errorf("unknown ensemble vote %q", e.Vote)
+	}
+}
+
+// tally counts the votes for every test in the order of tests. Tests with at
+// least need votes are selected with the most confident reason. Their
+// confidence is averaged over all voters, a missing vote counting as zero, so
+// disagreement lowers it. disputed lists the IDs of tests some but
+// not all voters selected.
+func tally(answers [][]Selection, tests []testmeta.Metadata, need int) (selected []Selection, disputed []string) {
+	type count struct {
+		best  Selection
+		votes int
+		sum   float64
+	}
+	counts := map[string]*count{}
+	for _, answer := range answers {
+		seen := map[string]bool{}
+		for _, s := range answer {
+			id := s.Test.ID()
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			c, ok := counts[id]
+			if !ok {
+				c = &count{best: s}
+				counts[id] = c
+			} else if s.Confidence > c.best.Confidence {
+				c.best = s
+			}
+			c.votes++
+			c.sum += s.Confidence
+		}
+	}
+
+	for _, t := range tests {
+		c, ok := counts[t.ID()]
+		if !ok {
+			continue
+		}
+		if c.votes < len(answers) {
+			disputed = append(disputed, fmt.Sprintf("%s (%d/%d)", t.ID(), c.votes, len(answers)))
+		}
+		if c.votes < need {
+			continue
+		}
+		s := c.best
+		s.Confidence = c.sum / float64(len(answers))
+		s.Votes, s.Voters = c.votes, len(answers)
+		selected = append(selected, s)
+	}
+	return selected, disputed
+}
@@ -0,0 +1,44 @@
+package llmselector
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ErrSuspiciousResponse is returned for answers that cannot come from an
+// honest reading of the prompt: unknown IDs or more selections than allowed.
+// Repository content such as test names is untrusted and may try to steer
+// the model, so such answers are rejected rather than trimmed.
+var ErrSuspiciousResponse = errors.New("suspicious model response")
+
+// checkAnswers rejects answers naming anything known does not accept or
+// exceeding limit entries. Repeated IDs are only logged.
+func checkAnswers(answers []answer, limit int, known func(id string) bool) error {
+	var problems []string
+	if len(answers) > limit {
+		problems = append(problems, fmt.Sprintf("%d selections for %d candidates", len(answers), limit))
+	}
+	seen := map[string]bool{}
+	for _, a := range answers {
+		id := strings.ToLower(a.ID)
+		switch {
+		case seen[id]:
+			log.Printf("selection: suspicious response: %q selected twice", a.ID)
+		case !known(a.ID):
+			problems = append(problems, fmt.Sprintf("%q is not a candidate", a.ID))
+		}
+		seen[id] = true
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return suspicious(strings.Join(problems, "; "))
+}
+
+// suspicious logs and returns an ErrSuspiciousResponse.
+func suspicious(detail string) error {
+	log.Printf("selection: suspicious response rejected: %s", detail)
+	return fmt.Errorf("%w: %s", ErrSuspiciousResponse, detail)
+}
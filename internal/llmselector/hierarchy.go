@@ -0,0 +1,174 @@
+package llmselector
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/prompt"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// maxSummarySymbols caps how many exported symbols are listed per package.
+const maxSummarySymbols = 30
+
+// selectHierarchical asks the model which packages are affected by the
+// changes, then asks package by package which of their tests to run.
+func selectHierarchical(ctx context.Context, complete completeFunc, changes []diff.Change, tests []testmeta.Metadata, opts Options) ([]Selection, error) {
+	pkgs, err := testmeta.Packages(tests)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := selectPackages(ctx, complete, changes, pkgs, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(affected) == 0 {
+		log.Printf("hierarchical selection: no packages chosen, running all tests")
+		return selectAll(tests, "model selected no packages"), nil
+	}
+	for _, p := range affected {
+		log.Printf("hierarchical selection: package %s (confidence %.2f): %s", p.Package.Dir, p.Confidence, p.Reason)
+	}
+
+	byDir := map[string][]testmeta.Metadata{}
+	for _, t := range tests {
+		dir := filepath.ToSlash(filepath.Dir(t.File))
+		byDir[dir] = append(byDir[dir], t)
+	}
+	var batches [][]testmeta.Metadata
+	for _, p := range affected {
+		b, err := batchTests(changes, byDir[p.Package.Dir], opts)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, b...)
+	}
+	results, err := runBatches(ctx, complete, changes, batches, opts)
+	if err != nil {
+		return nil, err
+	}
+	return mergeSelections(results, tests, opts)
+}
+
+// packageSelection is a package chosen in the first hierarchical stage.
+type packageSelection struct {
+	Package    testmeta.Package
+	Reason     string
+	Confidence float64
+}
+
+// selectPackages runs the package-level stage, batching the summaries the
+// same way tests are batched.
+func selectPackages(ctx context.Context, complete completeFunc, changes []diff.Change, pkgs []testmeta.Package, opts Options) ([]packageSelection, error) {
+	batches, err := batchPackages(changes, pkgs, opts)
+	if err != nil {
+		return nil, err
+	}
+	results := make([][]packageSelection, len(batches))
+	err = forEach(ctx, len(batches), opts.Concurrency, func(ctx context.Context, i int) error {
+		p, err := buildPackagePrompt(changes, batches[i])
+		if err != nil {
+			return err
+		}
+		content, err := complete(ctx, p, selectPackagesTool)
+		if err != nil {
+			return fmt.Errorf("package batch %d/%d: %w", i+1, len(batches), err)
+		}
+		answers, err := parseAnswers(content)
+		if err == nil {
+			err = checkAnswers(answers, len(batches[i]), func(id string) bool { return len(matchPackages([]answer{{ID: id}}, batches[i])) > 0 })
+		}
+		if err != nil {
+			return fmt.Errorf("package batch %d/%d: %w", i+1, len(batches), err)
+		}
+		results[i] = matchPackages(answers, batches[i])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var affected []packageSelection
+	for _, r := range results {
+		affected = append(affected, r...)
+	}
+	return affected, nil
+}
+
+func batchPackages(changes []diff.Change, pkgs []testmeta.Package, opts Options) ([][]testmeta.Package, error) {
+	base, err := buildPackagePrompt(changes, nil)
+	if err != nil {
+		return nil, err
+	}
+	fixed := estimateTokens(base)
+	inputBudget := opts.ContextTokens - opts.MaxTokens - fixed
+	if inputBudget <= 0 {
+		return nil, fmt.Errorf("changes need about %d tokens, which does not fit a %d token context", fixed, opts.ContextTokens)
+	}
+
+	var batches [][]testmeta.Package
+	var cur []testmeta.Package
+	in, out := 0, 0
+	for _, p := range pkgs {
+		inCost := estimateTokens(packageLine(p))
+		outCost := estimateTokens(p.ImportPath) + answerOverhead
+		if len(cur) > 0 && (in+inCost > inputBudget || out+outCost > opts.MaxTokens) {
+			batches = append(batches, cur)
+			cur, in, out = nil, 0, 0
+		}
+		cur = append(cur, p)
+		in += inCost
+		out += outCost
+	}
+	if len(cur) > 0 {
+		batches = append(batches, cur)
+	}
+	return batches, nil
+}
+
+func buildPackagePrompt(changes []diff.Change, pkgs []testmeta.Package) (string, error) {
+	data := prompt.PackageSelectionData{Changes: changes}
+	for _, p := range pkgs {
+		data.Packages = append(data.Packages, packageSummary(p))
+	}
+	return prompt.Render(prompt.SelectPackages, data)
+}
+
+// packageSummary lists at most maxSummarySymbols exported symbols.
+func packageSummary(p testmeta.Package) prompt.Package {
+	summary := prompt.Package{ImportPath: p.ImportPath, Tests: p.Tests, Exported: p.Exported}
+	if len(p.Exported) > maxSummarySymbols {
+		summary.Exported = p.Exported[:maxSummarySymbols]
+		summary.More = len(p.Exported) - maxSummarySymbols
+	}
+	return summary
+}
+
+// packageLine approximates how a package is listed in the prompt, for
+// batching.
+func packageLine(p testmeta.Package) string {
+	s := packageSummary(p)
+	more := ""
+	if s.More > 0 {
+		more = fmt.Sprintf(" (+%d more)", s.More)
+	}
+	return fmt.Sprintf("- %s (%d tests) exports: %s%s\n", s.ImportPath, s.Tests, strings.Join(s.Exported, ", "), more)
+}
+
+// matchPackages returns the packages named by import path or directory, as
+// the prompt rendered them.
+func matchPackages(answers []answer, pkgs []testmeta.Package) []packageSelection {
+	var selected []packageSelection
+	for _, p := range pkgs {
+		for _, a := range answers {
+			if strings.EqualFold(a.ID, prompt.Field(p.ImportPath)) || strings.EqualFold(a.ID, prompt.Field(p.Dir)) {
+				selected = append(selected, packageSelection{Package: p, Reason: a.Reason, Confidence: a.Confidence})
+				break
+			}
+		}
+	}
+	return selected
+}
@@ -0,0 +1,205 @@
+package llmselector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/executor"
+	"github.com/example/mango/internal/history"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// ErrNoHistory is returned by History when no runs have been recorded, so a
+// chain can fall back to another strategy.
+var ErrNoHistory = errors.New("no recorded runs")
+
+// ErrNoSignal is returned by History, CoChange and ML when they have data but
+// no test scores at least their threshold. An empty selection would run
+// nothing, so a chain falls back instead.
+var ErrNoSignal = errors.New("no test scores above the threshold")
+
+// Weights of the History score components. Co-failure is the most specific
+// signal and weighs the most.
+const (
+	weightFailureRate = 0.3
+	weightCoFailure   = 0.5
+	weightRecency     = 0.2
+)
+
+// History selects tests from recorded runs without an LLM. A test scores
+// by its recent failure rate, how often it failed when the same files or
+// functions changed, and how recently it failed last.
+type History struct {
+	Store *history.Store
+	// Threshold is the lowest score, between 0 and 1, a test needs.
+	Threshold float64
+	// Max caps the number of tests, the highest scores first. Zero means no
+	// cap.
+	Max int
+	// Window is how many of the latest runs are considered. Zero uses all.
+	Window int
+	// HalfLife is the age at which a run counts half as much. Zero
+	// weighs every run the same.
+	HalfLife time.Duration
+
+	now func() time.Time
+}
+
+// testStats accumulates the weighted evidence about one test.
+type testStats struct {
+	ran, failed          float64
+	ranRelated, coFailed float64
+	failures, coFailures int
+	runs, relatedRuns    int
+	lastFailure          time.Time
+}
+
+// Select scores every test against the recorded runs.
+func (h History) Select(ctx context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]Selection, error) {
+	runs, err := h.Store.List()
+	if err != nil {
+		return nil, err
+	}
+	runs = snapshotFrom(ctx).runs(runs)
+	if len(runs) == 0 {
+		return nil, ErrNoHistory
+	}
+	if h.Window > 0 && len(runs) > h.Window {
+		runs = runs[:h.Window]
+	}
+	now := time.Now()
+	if h.now != nil {
+		now = h.now()
+	}
+
+	current := changeKeys(changes)
+	stats := map[string]*testStats{}
+	for _, r := range runs {
+		w := h.weight(now.Sub(r.Time))
+		related := relatedness(changeKeys(r.Changes), current)
+		for _, res := range r.Results {
+			if res.Quarantined || res.Outcome == executor.OutcomeSkip {
+				continue
+			}
+			s := stats[res.ID]
+			if s == nil {
+				s = &testStats{}
+				stats[res.ID] = s
+			}
+			failed := res.Outcome == executor.OutcomeFail
+			s.runs++
+			s.ran += w
+			if related > 0 {
+				s.relatedRuns++
+				s.ranRelated += w * related
+			}
+			if !failed {
+				continue
+			}
+			s.failures++
+			s.failed += w
+			if related > 0 {
+				s.coFailures++
+				s.coFailed += w * related
+			}
+			if r.Time.After(s.lastFailure) {
+				s.lastFailure = r.Time
+			}
+		}
+	}
+
+	var selected []Selection
+	for _, t := range tests {
+		s := stats[t.ID()]
+		if s == nil || s.failures == 0 {
+			continue
+		}
+		score := weightFailureRate*ratio(s.failed, s.ran) +
+			weightCoFailure*ratio(s.coFailed, s.ranRelated) +
+			weightRecency*h.weight(now.Sub(s.lastFailure))
+		if score < h.Threshold {
+			continue
+		}
+		selected = append(selected, Selection{Test: t, Reason: s.reason(now), Confidence: math.Min(score, 1)})
+	}
+	if len(selected) == 0 {
+		return nil, ErrNoSignal
+	}
+	sort.SliceStable(selected, func(i, j int) bool { return selected[i].Confidence > selected[j].Confidence })
+	if h.Max > 0 && len(selected) > h.Max {
+		selected = selected[:h.Max]
+	}
+	return selected, nil
+}
+
+// weight decays with age by HalfLife.
+func (h History) weight(age time.Duration) float64 {
+	if h.HalfLife <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(age)/float64(h.HalfLife))
+}
+
+func (s *testStats) reason(now time.Time) string {
+	parts := []string{fmt.Sprintf("failed in %d of %d recorded runs", s.failures, s.runs)}
+	if s.relatedRuns > 0 {
+		parts = append(parts, fmt.Sprintf("%d of %d with the same changes", s.coFailures, s.relatedRuns))
+	}
+	parts = append(parts, "last "+age(now.Sub(s.lastFailure))+" ago")
+	return strings.Join(parts, ", ")
+}
+
+// changeKeys identifies changed files and functions, e.g. a/a.go and
+// a/a.go#Post.
+func changeKeys(changes []diff.Change) map[string]bool {
+	keys := map[string]bool{}
+	for _, c := range changes {
+		file := filepath.ToSlash(c.File)
+		keys[file] = true
+		for _, fn := range c.Functions {
+			keys[file+"#"+fn] = true
+		}
+	}
+	return keys
+}
+
+// relatedness is 1 when two sets of changes touch a common function, 0.5
+// when they only share a file and 0 otherwise.
+func relatedness(a, b map[string]bool) float64 {
+	related := 0.0
+	for k := range a {
+		if !b[k] {
+			continue
+		}
+		if strings.Contains(k, "#") {
+			return 1
+		}
+		related = 0.5
+	}
+	return related
+}
+
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
+
+// age renders a duration in the largest whole unit: 3d, 5h or 10m.
+func age(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%dm", int(d/time.Minute))
+}
@@ -11,7 +11,7 @@ import (
 )
 
 type FakeSelector struct {
-	SelectStub        func(context.Context, []diff.Change, []testmeta.Metadata) ([]testmeta.Metadata, error)
+	SelectStub        func(context.Context, []diff.Change, []testmeta.Metadata) ([]llmselector.Selection, error)
 	selectMutex       sync.RWMutex
 	selectArgsForCall []struct {
 		arg1 context.Context
@@ -19,18 +19,18 @@ type FakeSelector struct {
 		arg3 []testmeta.Metadata
 	}
 	selectReturns struct {
-		result1 []testmeta.Metadata
+		result1 []llmselector.Selection
 		result2 error
 	}
 	selectReturnsOnCall map[int]struct {
-		result1 []testmeta.Metadata
+		result1 []llmselector.Selection
 		result2 error
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeSelector) Select(arg1 context.Context, arg2 []diff.Change, arg3 []testmeta.Metadata) ([]testmeta.Metadata, error) {
+func (fake *FakeSelector) Select(arg1 context.Context, arg2 []diff.Change, arg3 []testmeta.Metadata) ([]llmselector.Selection, error) {
 	var arg2Copy []diff.Change
 	if arg2 != nil {
 		arg2Copy = make([]diff.Change, len(arg2))
@@ -67,7 +67,7 @@ func (fake *FakeSelector) SelectCallCount() int {
 	return len(fake.selectArgsForCall)
 }
 
-func (fake *FakeSelector) SelectCalls(stub func(context.Context, []diff.Change, []testmeta.Metadata) ([]testmeta.Metadata, error)) {
+func (fake *FakeSelector) SelectCalls(stub func(context.Context, []diff.Change, []testmeta.Metadata) ([]llmselector.Selection, error)) {
 	fake.selectMutex.Lock()
 	defer fake.selectMutex.Unlock()
 	fake.SelectStub = stub
@@ -80,28 +80,28 @@ func (fake *FakeSelector) SelectArgsForCall(i int) (context.Context, []diff.Chan
 	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
-func (fake *FakeSelector) SelectReturns(result1 []testmeta.Metadata, result2 error) {
+func (fake *FakeSelector) SelectReturns(result1 []llmselector.Selection, result2 error) {
 	fake.selectMutex.Lock()
 	defer fake.selectMutex.Unlock()
 	fake.SelectStub = nil
 	fake.selectReturns = struct {
-		result1 []testmeta.Metadata
+		result1 []llmselector.Selection
 		result2 error
 	}{result1, result2}
 }
 
-func (fake *FakeSelector) SelectReturnsOnCall(i int, result1 []testmeta.Metadata, result2 error) {
+func (fake *FakeSelector) SelectReturnsOnCall(i int, result1 []llmselector.Selection, result2 error) {
 	fake.selectMutex.Lock()
 	defer fake.selectMutex.Unlock()
 	fake.SelectStub = nil
 	if fake.selectReturnsOnCall == nil {
 		fake.selectReturnsOnCall = make(map[int]struct {
-			result1 []testmeta.Metadata
+			result1 []llmselector.Selection
 			result2 error
 		})
 	}
 	fake.selectReturnsOnCall[i] = struct {
-		result1 []testmeta.Metadata
+		result1 []llmselector.Selection
 		result2 error
 	}{result1, result2}
 }
@@ -0,0 +1,79 @@
+package llmselector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/history"
+	"github.com/example/mango/internal/ml"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// ML selects tests with the model trained by mango train. It fails with
+// ml.ErrNoModel until one is trained, so a chain can fall back.
+type ML struct {
+	// Path is the trained model.
+	Path string
+	// Store supplies the past failures of tests. Optional.
+	Store *history.Store
+	// Threshold is the lowest predicted failure probability selected.
+	Threshold float64
+	// Max caps the number of tests, the most likely failures first. Zero
+	// means no cap.
+	Max int
+	// Commits and MaxFiles bound the git history mined for co-changes, as
+	// for CoChange.
+	Commits  int
+	MaxFiles int
+
+	commitFiles func(rev string, limit int) ([][]string, error)
+}
+
+// Select predicts the failure probability of every test. It fails with
+// ErrNoSignal when no prediction reaches Threshold.
+func (s ML) Select(ctx context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]Selection, error) {
+	m, err := ml.Load(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	snap := snapshotFrom(ctx)
+	var runs []history.Run
+	if s.Store != nil {
+		if runs, err = s.Store.List(); err != nil {
+			return nil, err
+		}
+		runs = snap.runs(runs)
+	}
+	mine := s.commitFiles
+	if mine == nil {
+		mine = diff.CommitFiles
+	}
+	commits, err := mine(snap.Rev, s.Commits)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := &ml.Extractor{Module: testmeta.ModulePath("."), Commits: commits, MaxFiles: s.MaxFiles}
+	var selected []Selection
+	for i, x := range ext.Features(changes, runs, tests) {
+		p := m.Predict(x)
+		if p < s.Threshold {
+			continue
+		}
+		reason := fmt.Sprintf("predicted failure probability %.2f", p)
+		if why := m.Explain(x); why != "" {
+			reason += ": " + why
+		}
+		selected = append(selected, Selection{Test: tests[i], Reason: reason, Confidence: p})
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w: no predicted failure probability reaches %.2f", ErrNoSignal, s.Threshold)
+	}
+	sort.SliceStable(selected, func(i, j int) bool { return selected[i].Confidence > selected[j].Confidence })
+	if s.Max > 0 && len(selected) > s.Max {
+		selected = selected[:s.Max]
+	}
+	return selected, nil
+}
@@ -0,0 +1,110 @@
+package llmselector
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// Names of the must-run rules applied by SafetyNet.
+const (
+	RuleSamePackage = "same-package"
+	RuleEditedTest  = "edited-test"
+	RuleAlwaysRun   = "always-run"
+)
+
+// SourceSafetyNet is the Source of tests only SafetyNet selected.
+const SourceSafetyNet = "safety-net"
+
+// SafetyNet decorates a Selector with deterministic must-run rules. Tests in
+// the package of a changed Go file, tests in an edited _test.go file and tests
+// matching AlwaysRun are added to whatever Next selected.
+type SafetyNet struct {
+	Next Selector
+	// AlwaysRun holds test IDs, names or path.Match patterns over either,
+	// e.g. "internal/auth:*" or "TestSmoke*".
+	AlwaysRun []string
+	// AlwaysRunOnly disables the change-based rules and keeps AlwaysRun.
+	AlwaysRunOnly bool
+}
+
+// Select unions the selection of Next with the must-run rules. Selections
+// record the rules that matched them in Rules.
+func (s SafetyNet) Select(ctx context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]Selection, error) {
+	selected, err := s.Next.Select(ctx, changes, tests)
+	if err != nil {
+		return nil, err
+	}
+
+	index := map[string]int{}
+	for i, sel := range selected {
+		index[sel.Test.ID()] = i
+	}
+	for _, t := range tests {
+		rules, reason := s.mustRun(changes, t)
+		if len(rules) == 0 {
+			continue
+		}
+		if i, ok := index[t.ID()]; ok {
+			selected[i].Rules = append(selected[i].Rules, rules...)
+			continue
+		}
+		index[t.ID()] = len(selected)
+		selected = append(selected, Selection{Test: t, Reason: reason, Confidence: 1, Source: SourceSafetyNet, Rules: rules})
+	}
+	return selected, nil
+}
+
+// mustRun returns the rules that force t to run and a reason for the first.
+func (s SafetyNet) mustRun(changes []diff.Change, t testmeta.Metadata) ([]string, string) {
+	var rules []string
+	var reason string
+	add := func(rule, why string) {
+		if reason == "" {
+			reason = why
+		}
+		rules = append(rules, rule)
+	}
+
+	file := filepath.ToSlash(t.File)
+	if s.AlwaysRunOnly {
+		changes = nil
+	}
+	for _, c := range changes {
+		if filepath.ToSlash(c.File) == file {
+			add(RuleEditedTest, "test file was edited")
+			break
+		}
+	}
+	for _, c := range changes {
+		changed := filepath.ToSlash(c.File)
+		if strings.HasSuffix(changed, ".go") && path.Dir(changed) == path.Dir(file) && changed != file {
+			add(RuleSamePackage, "same package as changed file "+changed)
+			break
+		}
+	}
+	for _, pattern := range s.AlwaysRun {
+		if matchTest(pattern, t) {
+			add(RuleAlwaysRun, "always run "+pattern)
+			break
+		}
+	}
+	return rules, reason
+}
+
+// matchTest reports whether pattern names t by ID or name.
+func matchTest(pattern string, t testmeta.Metadata) bool {
+	for _, name := range []string{t.ID(), t.Name} {
+		if name == pattern {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package llmselector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/example/mango/internal/llm"
+)
+
+// selectionSchema is the JSON schema every structured answer must follow.
+var selectionSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"selections": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"id":         map[string]interface{}{"type": "string", "description": "identifier exactly as listed in the prompt"},
+					"reason":     map[string]interface{}{"type": "string", "description": "why it should run"},
+					"confidence": map[string]interface{}{"type": "number", "description": "between 0 and 1"},
+				},
+				"required": []string{"id", "reason", "confidence"},
+			},
+		},
+	},
+	"required": []string{"selections"},
+}
+
+var (
+	selectTestsTool = llm.Tool{
+		Name:        "select_tests",
+		Description: "Report which of the listed tests should run.",
+		Schema:      selectionSchema,
+	}
+	selectPackagesTool = llm.Tool{
+		Name:        "select_packages",
+		Description: "Report which of the listed packages are affected.",
+		Schema:      selectionSchema,
+	}
+)
+
+// answer is a single entry of a structured selection response.
+type answer struct {
+	ID         string  `json:"id"`
+	Reason     string  `json:"reason"`
+	Confidence float64 `json:"confidence"`
+}
+
+// parseAnswers decodes a structured selection response. Anything that is
+// not a JSON object following selectionSchema is rejected.
+func parseAnswers(resp string) ([]answer, error) {
+	var out struct {
+		Selections *[]answer `json:"selections"`
+	}
+	if err := json.Unmarshal([]byte(resp), &out); err != nil {
+		return nil, fmt.Errorf("could not parse response: %w", err)
+	}
+	if out.Selections == nil {
+		return nil, errors.New("could not parse response: missing selections")
+	}
+	answers := *out.Selections
+	for i := range answers {
+		answers[i].ID = strings.TrimSpace(answers[i].ID)
+		answers[i].Confidence = min(max(answers[i].Confidence, 0), 1)
+	}
+	return answers, nil
+}
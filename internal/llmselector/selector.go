@@ -1,19 +1,14 @@
 package llmselector
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
-	"net/url"
 	"strings"
-	"time"
-
-	openai "github.com/sashabaranov/go-openai"
 
 	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/llm"
+	"github.com/example/mango/internal/prompt"
 	"github.com/example/mango/internal/testmeta"
 )
 
@@ -21,260 +16,117 @@ import (
 //
 //go:generate counterfeiter . Selector
 type Selector interface {
-	Select(ctx context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]testmeta.Metadata, error)
+	Select(ctx context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]Selection, error)
 }
 
-// OpenAISelector implements Selector using the OpenAI API.
-// Provider represents an LLM provider.
-type Provider string
-
-const (
-	ProviderOpenAI    Provider = "openai"
-	ProviderAnthropic Provider = "anthropic"
-	ProviderGemini    Provider = "gemini"
-)
-
-// NewSelector returns a Selector for the given provider.
-func NewSelector(provider Provider, token string) Selector {
-	switch provider {
-	case ProviderAnthropic:
-		return NewAnthropicSelector(token)
-	case ProviderGemini:
-		return NewGeminiSelector(token)
-	case ProviderOpenAI:
-		fallthrough
-	default:
-		return NewOpenAISelector(token)
-	}
+// Selection is a test chosen by a Selector together with the model's
+// rationale for running it.
+type Selection struct {
+	Test       testmeta.Metadata
+	Reason     string
+	Confidence float64
+	// Source names the strategy that produced the selection.
+	Source string
+	// Rules lists the must-run rules that matched the test, see SafetyNet.
+	Rules []string
+	// Votes is how many of Voters ensemble members selected the test.
+	Votes, Voters int
 }
 
-// OpenAISelector implements Selector using the OpenAI API.
-type OpenAISelector struct {
-	Client *openai.Client
+// ErrNoClient is returned by an LLMSelector without a client.
+var ErrNoClient = errors.New("no LLM client configured")
+
+// Options bounds the size of the requests a selector sends and tunes how
+// answers are combined.
+type Options struct {
+	// ContextTokens is the model's context window in tokens.
+	ContextTokens int
+	// MaxTokens is the completion budget reserved for the answer.
+	MaxTokens int
+	// Concurrency caps how many batches are queried at once.
+	Concurrency int
+	// Hierarchical first asks which packages are affected and then which
+	// tests to run inside each of them.
+	Hierarchical bool
+	// MinConfidence drops selections the model is less sure about.
+	MinConfidence float64
+	// MaxSelections rejects answers selecting more tests than this. Zero
+	// only limits each answer to its candidates.
+	MaxSelections int
 }
 
-// NewOpenAISelector creates an OpenAI-based selector. If token is empty, nil is returned.
-func NewOpenAISelector(token string) *OpenAISelector {
-	if token == "" {
-		return nil
-	}
-	c := openai.NewClient(token)
-	return &OpenAISelector{Client: c}
-}
+// DefaultConcurrency is used when Options.Concurrency is not set.
+const DefaultConcurrency = 4
 
-// AnthropicSelector implements Selector using the Anthropic API.
-type AnthropicSelector struct {
-	Token  string
-	Client *http.Client
-	Model  string
+// LLMSelector implements Selector on top of any llm.Client.
+type LLMSelector struct {
+	Client  llm.Client
+	Options Options
 }
 
-// NewAnthropicSelector creates a selector for Anthropic Claude.
-func NewAnthropicSelector(token string) *AnthropicSelector {
-	if token == "" {
-		return nil
+// New creates a selector that asks client which tests to run. ContextTokens
+// and MaxTokens should match the client's model; when unset the OpenAI
+// defaults are assumed.
+func New(client llm.Client, opts Options) *LLMSelector {
+	def := llm.Options{}.WithDefaults(llm.ProviderOpenAI)
+	if opts.ContextTokens <= 0 {
+		opts.ContextTokens = def.ContextTokens
 	}
-	return &AnthropicSelector{Token: token, Client: &http.Client{Timeout: 60 * time.Second}, Model: "claude-3-opus-20240229"}
-}
-
-// GeminiSelector implements Selector using the Gemini API.
-type GeminiSelector struct {
-	Token  string
-	Client *http.Client
-	Model  string
-}
-
-// NewGeminiSelector creates a selector for Google's Gemini.
-func NewGeminiSelector(token string) *GeminiSelector {
-	if token == "" {
-		return nil
+	if opts.MaxTokens <= 0 {
+		opts.MaxTokens = def.MaxTokens
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
 	}
-	return &GeminiSelector{Token: token, Client: &http.Client{Timeout: 60 * time.Second}, Model: "gemini-pro"}
+	return &LLMSelector{Client: client, Options: opts}
 }
 
 // Select asks the LLM which tests to run based on changes.
-func (o *OpenAISelector) Select(ctx context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]testmeta.Metadata, error) {
-	if o == nil || o.Client == nil {
-		return tests, nil
-	}
-
-	prompt := buildPrompt(changes, tests)
-	req := openai.ChatCompletionRequest{
-		Model: openai.GPT3Dot5Turbo,
-		Messages: []openai.ChatCompletionMessage{{
-			Role:    openai.ChatMessageRoleUser,
-			Content: prompt,
-		}},
-	}
-	resp, err := o.Client.CreateChatCompletion(ctx, req)
-	if err != nil {
-		return nil, err
-	}
-	if len(resp.Choices) == 0 {
-		return nil, errors.New("no choices returned")
-	}
-	content := resp.Choices[0].Message.Content
-	names, err := parseResponse(content)
-	if err != nil {
-		return nil, err
-	}
-
-	var selected []testmeta.Metadata
-	for _, n := range names {
-		for _, t := range tests {
-			if strings.EqualFold(t.Name, n) {
-				selected = append(selected, t)
-			}
-		}
+func (s *LLMSelector) Select(ctx context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]Selection, error) {
+	if s == nil || s.Client == nil {
+		return nil, ErrNoClient
 	}
-	if len(selected) == 0 {
-		// fallback run all tests
-		return tests, nil
-	}
-	return selected, nil
+	return selectTests(ctx, s.complete, changes, tests, s.Options)
 }
 
-// Select asks Anthropic which tests to run.
-func (a *AnthropicSelector) Select(ctx context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]testmeta.Metadata, error) {
-	if a == nil || a.Token == "" {
-		return tests, nil
-	}
-
-	prompt := buildPrompt(changes, tests)
-	body := map[string]interface{}{
-		"model":      a.Model,
-		"max_tokens": 512,
-		"messages": []map[string]string{{
-			"role":    "user",
-			"content": prompt,
-		}},
-	}
-	data, _ := json.Marshal(body)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.anthropic.com/v1/messages", bytes.NewReader(data))
+func (s *LLMSelector) complete(ctx context.Context, prompt string, tool llm.Tool) (string, error) {
+	resp, err := s.Client.ChatCompletion(ctx, llm.Request{Prompt: prompt, Tool: &tool})
 	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", a.Token)
-	req.Header.Set("anthropic-version", "2023-06-01")
-	resp, err := a.Client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	var out struct {
-		Content []struct {
-			Text string `json:"text"`
-		} `json:"content"`
+		return "", err
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, err
-	}
-	if len(out.Content) == 0 {
-		return nil, errors.New("empty response")
-	}
-	names, err := parseResponse(out.Content[0].Text)
-	if err != nil {
-		return nil, err
-	}
-	return filterTests(names, tests), nil
+	return resp.Text, nil
 }
 
-// Select asks Gemini which tests to run.
-func (g *GeminiSelector) Select(ctx context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]testmeta.Metadata, error) {
-	if g == nil || g.Token == "" {
-		return tests, nil
-	}
-
-	prompt := buildPrompt(changes, tests)
-	body := map[string]interface{}{
-		"contents": []map[string]interface{}{
-			{"parts": []map[string]string{{"text": prompt}}},
-		},
-	}
-	data, _ := json.Marshal(body)
-	endpoint := "https://generativelanguage.googleapis.com/v1beta/models/" + url.PathEscape(g.Model) + ":generateContent?key=" + url.QueryEscape(g.Token)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := g.Client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	var out struct {
-		Candidates []struct {
-			Content struct {
-				Parts []struct {
-					Text string `json:"text"`
-				} `json:"parts"`
-			} `json:"content"`
-		} `json:"candidates"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, err
-	}
-	if len(out.Candidates) == 0 || len(out.Candidates[0].Content.Parts) == 0 {
-		return nil, errors.New("empty response")
-	}
-	names, err := parseResponse(out.Candidates[0].Content.Parts[0].Text)
-	if err != nil {
-		return nil, err
-	}
-	return filterTests(names, tests), nil
+func buildPrompt(changes []diff.Change, tests []testmeta.Metadata) (string, error) {
+	return prompt.Render(prompt.SelectTests, prompt.SelectionData{Changes: changes, Tests: tests})
 }
 
-func buildPrompt(changes []diff.Change, tests []testmeta.Metadata) string {
-	var b strings.Builder
-	b.WriteString("Recent code changes:\n")
-	for _, c := range changes {
-		if len(c.Functions) > 0 {
-			b.WriteString(fmt.Sprintf("- %s: %s\n", c.File, strings.Join(c.Functions, ", ")))
-		} else {
-			b.WriteString(fmt.Sprintf("- %s\n", c.File))
-		}
-	}
-	b.WriteString("\nAvailable tests:\n")
-	for i, t := range tests {
-		b.WriteString(fmt.Sprintf("%d. %s\n", i+1, t.Name))
-	}
-	b.WriteString("\nRespond with a JSON array of test names to run.")
-	return b.String()
+// testLine approximates how a test is listed in the prompt, for batching.
+func testLine(t testmeta.Metadata) string {
+	return fmt.Sprintf("- %s\n", t.ID())
 }
 
-func parseResponse(resp string) ([]string, error) {
-	var names []string
-	if err := json.Unmarshal([]byte(resp), &names); err == nil {
-		return names, nil
-	}
-
-	// fallback: split by lines
-	lines := strings.Split(resp, "\n")
-	for _, l := range lines {
-		l = strings.TrimSpace(l)
-		if l != "" {
-			names = append(names, l)
+// matchTests resolves the model's answers against the candidate tests. An
+// answer matches a test by ID, or by name when the model dropped the package,
+// as the prompt rendered them.
+func matchTests(answers []answer, candidates []testmeta.Metadata) []Selection {
+	var selected []Selection
+	for _, a := range answers {
+		for _, t := range candidates {
+			if a.ID == prompt.Field(t.ID()) || strings.EqualFold(a.ID, prompt.Field(t.Name)) {
+				selected = append(selected, Selection{Test: t, Reason: a.Reason, Confidence: a.Confidence})
+			}
 		}
 	}
-	if len(names) == 0 {
-		return nil, errors.New("could not parse response")
-	}
-	return names, nil
+	return selected
 }
 
-func filterTests(names []string, all []testmeta.Metadata) []testmeta.Metadata {
-	var selected []testmeta.Metadata
-	for _, n := range names {
-		for _, t := range all {
-			if strings.EqualFold(t.Name, n) {
-				selected = append(selected, t)
-			}
-		}
-	}
-	if len(selected) == 0 {
-		return all
+// selectAll selects every test for the given reason, used when no model
+// answer narrows the suite down.
+func selectAll(tests []testmeta.Metadata, reason string) []Selection {
+	selected := make([]Selection, len(tests))
+	for i, t := range tests {
+		selected[i] = Selection{Test: t, Reason: reason, Confidence: 1}
 	}
 	return selected
 }
@@ -0,0 +1,52 @@
+package llmselector
+
+import (
+	"context"
+	"time"
+
+	"github.com/example/mango/internal/history"
+)
+
+// Snapshot limits the strategies that learn from the run history and the git
+// log to what was known before a commit. Evaluating that commit must not let
+// its own results, or later ones, into the selection.
+type Snapshot struct {
+	// Before leaves out runs recorded at or after it.
+	Before time.Time
+	// Heads leaves out runs of these commits, whenever they were recorded.
+	Heads []string
+	// Rev is where the git log is mined from, e.g. the parent commit.
+	Rev string
+}
+
+type snapshotKey struct{}
+
+// WithSnapshot returns a context under which History, CoChange and ML only
+// see what s allows.
+func WithSnapshot(ctx context.Context, s Snapshot) context.Context {
+	return context.WithValue(ctx, snapshotKey{}, s)
+}
+
+func snapshotFrom(ctx context.Context) Snapshot {
+	s, _ := ctx.Value(snapshotKey{}).(Snapshot)
+	return s
+}
+
+// runs keeps the runs the snapshot allows. The zero snapshot keeps all.
+func (s Snapshot) runs(runs []history.Run) []history.Run {
+	if s.Before.IsZero() && len(s.Heads) == 0 {
+		return runs
+	}
+	excluded := map[string]bool{}
+	for _, h := range s.Heads {
+		excluded[h] = true
+	}
+	var kept []history.Run
+	for _, r := range runs {
+		if (!s.Before.IsZero() && !r.Time.Before(s.Before)) || excluded[r.Head] {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
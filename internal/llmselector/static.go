@@ -0,0 +1,82 @@
+package llmselector
+
+import (
+	"context"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// Static selects tests without an LLM: tests in the package of a changed Go
+// file, tests in an edited test file, and tests whose file imports a changed
+// package.
+type Static struct{}
+
+// Select applies the static rules to the changes.
+func (Static) Select(_ context.Context, changes []diff.Change, tests []testmeta.Metadata) ([]Selection, error) {
+	changedDirs := map[string]string{}
+	changedFiles := map[string]bool{}
+	module := testmeta.ModulePath(".")
+	changedPkgs := map[string]bool{}
+	for _, c := range changes {
+		file := filepath.ToSlash(c.File)
+		changedFiles[file] = true
+		if strings.HasSuffix(file, ".go") {
+			dir := path.Dir(file)
+			changedDirs[dir] = file
+			if module != "" {
+				changedPkgs[path.Join(module, dir)] = true
+			}
+		}
+	}
+
+	imports := map[string][]string{}
+	var selected []Selection
+	for _, t := range tests {
+		file := filepath.ToSlash(t.File)
+		if changedFiles[file] {
+			selected = append(selected, Selection{Test: t, Reason: "test file was edited", Confidence: 1})
+			continue
+		}
+		if src, ok := changedDirs[path.Dir(file)]; ok {
+			selected = append(selected, Selection{Test: t, Reason: "same package as changed file " + src, Confidence: 1})
+			continue
+		}
+		if len(changedPkgs) == 0 {
+			continue
+		}
+		deps, ok := imports[file]
+		if !ok {
+			deps = fileImports(t.File)
+			imports[file] = deps
+		}
+		for _, dep := range deps {
+			if changedPkgs[dep] {
+				selected = append(selected, Selection{Test: t, Reason: "imports changed package " + dep, Confidence: 1})
+				break
+			}
+		}
+	}
+	return selected, nil
+}
+
+// fileImports lists the import paths of a Go file, or nil if it cannot be parsed.
+func fileImports(file string) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, imp := range f.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err == nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
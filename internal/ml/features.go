@@ -0,0 +1,328 @@
+package ml
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"math"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/executor"
+	"github.com/example/mango/internal/history"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// FeatureNames describes the features, in the order of feature vectors.
+// Every feature lies between 0 and 1.
+var FeatureNames = []string{
+	"test edited",
+	"package proximity",
+	"imports changed package",
+	"shared identifiers",
+	"co-change",
+	"churn",
+	"failure rate",
+	"co-failure rate",
+}
+
+const (
+	featureEdited = iota
+	featureProximity
+	featureImports
+	featureIdentifiers
+	featureCoChange
+	featureChurn
+	featureFailureRate
+	featureCoFailureRate
+)
+
+// Extractor computes the features of tests for a change. Parsed test files
+// are cached, so one extractor serves many changes.
+type Extractor struct {
+	// Module is the module path, used to tell which packages changed.
+	Module string
+	// Commits are the files of each mined commit, as from diff.CommitFiles.
+	// Features uses them for the change being predicted.
+	Commits [][]string
+	// CommitsAt mines the commits reachable from a revision. Dataset uses it
+	// instead of Commits, so no example sees commits made after its run.
+	CommitsAt func(rev string) ([][]string, error)
+	// MaxFiles ignores commits touching more files when counting
+	// co-changes. Zero keeps all.
+	MaxFiles int
+
+	files map[string]*testFile
+}
+
+// testFile is what static analysis knows about a test file.
+type testFile struct {
+	imports []string
+	idents  map[string]bool
+}
+
+// past counts the earlier results of one test.
+type past struct {
+	ran, failed               int
+	ranRelated, failedRelated int
+}
+
+// Features returns the feature vector of every test for changes. Past
+// failures are counted over runs, which must not include the run being
+// predicted.
+func (e *Extractor) Features(changes []diff.Change, runs []history.Run, tests []testmeta.Metadata) [][]float64 {
+	return e.features(changes, e.Commits, runs, tests)
+}
+
+// features computes the feature vectors with co-change and churn counted
+// over commits.
+func (e *Extractor) features(changes []diff.Change, commits [][]string, runs []history.Run, tests []testmeta.Metadata) [][]float64 {
+	changedFiles := map[string]bool{}
+	changedDirs := map[string]bool{}
+	changedPkgs := map[string]bool{}
+	var functions []string
+	for _, c := range changes {
+		file := filepath.ToSlash(c.File)
+		changedFiles[file] = true
+		if strings.HasSuffix(file, ".go") {
+			changedDirs[path.Dir(file)] = true
+			if e.Module != "" {
+				changedPkgs[path.Join(e.Module, path.Dir(file))] = true
+			}
+		}
+		functions = append(functions, c.Functions...)
+	}
+	coChange, churn := e.coChanges(commits, changedFiles)
+	failures := pastFailures(changedFiles, runs)
+
+	out := make([][]float64, len(tests))
+	for i, t := range tests {
+		file := filepath.ToSlash(t.File)
+		tf := e.testFile(t.File)
+		x := make([]float64, len(FeatureNames))
+		if changedFiles[file] {
+			x[featureEdited] = 1
+		}
+		x[featureProximity] = proximity(path.Dir(file), changedDirs)
+		for _, imp := range tf.imports {
+			if changedPkgs[imp] {
+				x[featureImports] = 1
+				break
+			}
+		}
+		if len(functions) > 0 {
+			shared := 0
+			for _, fn := range functions {
+				if tf.idents[fn] {
+					shared++
+				}
+			}
+			x[featureIdentifiers] = float64(shared) / float64(len(functions))
+		}
+		x[featureCoChange] = coChange[file]
+		if len(commits) > 0 {
+			x[featureChurn] = math.Log1p(float64(churn[file])) / math.Log1p(float64(len(commits)))
+		}
+		if p := failures[t.ID()]; p != nil {
+			x[featureFailureRate] = ratio(p.failed, p.ran)
+			x[featureCoFailureRate] = ratio(p.failedRelated, p.ranRelated)
+		}
+		out[i] = x
+	}
+	return out
+}
+
+// Dataset turns recorded runs into training examples: the features of each
+// test result against the runs recorded before it and the commits reachable
+// from its base, mined with CommitsAt. Results of tests that no longer exist,
+// skipped and quarantined results are left out, as are runs whose base can
+// no longer be mined, e.g. after a rebase.
+func (e *Extractor) Dataset(runs []history.Run, tests []testmeta.Metadata) []Example {
+	runs = append([]history.Run(nil), runs...)
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Time.Before(runs[j].Time) })
+	byID := map[string]testmeta.Metadata{}
+	for _, t := range tests {
+		byID[t.ID()] = t
+	}
+
+	mined := map[string][][]string{}
+	var examples []Example
+	for i, r := range runs {
+		commits, err := e.commitsAt(r.Base, mined)
+		if err != nil {
+			log.Printf("ml: leaving out run %s: %v", r.ID, err)
+			continue
+		}
+		var ran []testmeta.Metadata
+		var failed []bool
+		for _, res := range r.Results {
+			t, ok := byID[res.ID]
+			if !ok || res.Quarantined || res.Outcome == executor.OutcomeSkip {
+				continue
+			}
+			ran = append(ran, t)
+			failed = append(failed, res.Outcome == executor.OutcomeFail)
+		}
+		for j, x := range e.features(r.Changes, commits, runs[:i], ran) {
+			examples = append(examples, Example{X: x, Failed: failed[j]})
+		}
+	}
+	return examples
+}
+
+// commitsAt mines the commits reachable from rev once per revision. Without
+// a revision or CommitsAt there are none, rather than later ones.
+func (e *Extractor) commitsAt(rev string, mined map[string][][]string) ([][]string, error) {
+	if rev == "" || e.CommitsAt == nil {
+		return nil, nil
+	}
+	if commits, ok := mined[rev]; ok {
+		return commits, nil
+	}
+	commits, err := e.CommitsAt(rev)
+	if err != nil {
+		return nil, err
+	}
+	mined[rev] = commits
+	return commits, nil
+}
+
+// coChanges returns, for every test file, the highest share of the commits
+// touching a changed source file that also touched the test file, and how
+// many commits touched each test file.
+func (e *Extractor) coChanges(commits [][]string, changed map[string]bool) (map[string]float64, map[string]int) {
+	seen := map[string]int{}
+	both := map[[2]string]int{}
+	churn := map[string]int{}
+	for _, files := range commits {
+		var sources, testFiles []string
+		for _, f := range files {
+			switch {
+			case strings.HasSuffix(f, "_test.go"):
+				testFiles = append(testFiles, f)
+				churn[f]++
+			case changed[f]:
+				sources = append(sources, f)
+			}
+		}
+		if e.MaxFiles > 0 && len(files) > e.MaxFiles {
+			continue
+		}
+		for _, s := range sources {
+			seen[s]++
+			for _, t := range testFiles {
+				both[[2]string{s, t}]++
+			}
+		}
+	}
+	coChange := map[string]float64{}
+	for key, n := range both {
+		coChange[key[1]] = math.Max(coChange[key[1]], float64(n)/float64(seen[key[0]]))
+	}
+	return coChange, churn
+}
+
+// testFile parses a test file once. A file that cannot be parsed has no
+// imports or identifiers.
+func (e *Extractor) testFile(file string) *testFile {
+	if tf, ok := e.files[file]; ok {
+		return tf
+	}
+	if e.files == nil {
+		e.files = map[string]*testFile{}
+	}
+	tf := &testFile{idents: map[string]bool{}}
+	e.files[file] = tf
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return tf
+	}
+	for _, imp := range f.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err == nil {
+			tf.imports = append(tf.imports, p)
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			tf.idents[id.Name] = true
+		}
+		return true
+	})
+	return tf
+}
+
+// pastFailures counts the results of every test in runs, and separately in
+// the runs that changed one of the changed files.
+func pastFailures(changed map[string]bool, runs []history.Run) map[string]*past {
+	out := map[string]*past{}
+	for _, r := range runs {
+		related := false
+		for _, c := range r.Changes {
+			if changed[filepath.ToSlash(c.File)] {
+				related = true
+				break
+			}
+		}
+		for _, res := range r.Results {
+			if res.Quarantined || res.Outcome == executor.OutcomeSkip {
+				continue
+			}
+			p := out[res.ID]
+			if p == nil {
+				p = &past{}
+				out[res.ID] = p
+			}
+			failed := res.Outcome == executor.OutcomeFail
+			p.ran++
+			if failed {
+				p.failed++
+			}
+			if related {
+				p.ranRelated++
+				if failed {
+					p.failedRelated++
+				}
+			}
+		}
+	}
+	return out
+}
+
+// proximity is 1 for a test in a changed directory and falls with the
+// number of directories between it and the nearest one.
+func proximity(dir string, changed map[string]bool) float64 {
+	best := 0.0
+	for c := range changed {
+		best = math.Max(best, 1/float64(1+distance(dir, c)))
+	}
+	return best
+}
+
+// distance counts the steps up and down the tree from directory a to b.
+func distance(a, b string) int {
+	as, bs := segments(a), segments(b)
+	common := 0
+	for common < len(as) && common < len(bs) && as[common] == bs[common] {
+		common++
+	}
+	return len(as) + len(bs) - 2*common
+}
+
+func segments(dir string) []string {
+	if dir == "." || dir == "" {
+		return nil
+	}
+	return strings.Split(dir, "/")
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
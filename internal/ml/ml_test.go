@@ -0,0 +1,172 @@
+package ml
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/history"
+	"github.com/example/mango/internal/testmeta"
+)
+
+var _ = Describe("Extractor", func() {
+	tests := []testmeta.Metadata{
+		{Name: "TestLedger", File: "billing/ledger_test.go"},
+		{Name: "TestInvoice", File: "billing/invoice/invoice_test.go"},
+		{Name: "TestDial", File: "net/net_test.go"},
+	}
+
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		files := map[string]string{
+			"billing/ledger_test.go":          "package billing\n\nfunc TestLedger() { Post() }\n",
+			"billing/invoice/invoice_test.go": "package invoice\n\nimport \"example.com/shop/billing\"\n\nfunc TestInvoice() { billing.Total() }\n",
+			"net/net_test.go":                 "package net\n\nfunc TestDial() {}\n",
+		}
+		for name, src := range files {
+			Expect(os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644)).To(Succeed())
+		}
+		wd, err := os.Getwd()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chdir(dir)).To(Succeed())
+		DeferCleanup(os.Chdir, wd)
+	})
+
+	It("computes features from the change, the tests and both histories", func() {
+		e := &Extractor{
+			Module: "example.com/shop",
+			Commits: [][]string{
+				{"billing/ledger.go", "billing/ledger_test.go"},
+				{"billing/ledger.go"},
+				{"net/net_test.go"},
+			},
+		}
+		runs := []history.Run{
+			{Changes: []diff.Change{{File: "billing/ledger.go"}}, Results: []history.Result{
+				{ID: "billing:TestLedger", Outcome: "fail"}, {ID: "net:TestDial", Outcome: "pass"},
+			}},
+			{Changes: []diff.Change{{File: "docs/readme.md"}}, Results: []history.Result{
+				{ID: "billing:TestLedger", Outcome: "pass"}, {ID: "net:TestDial", Outcome: "skip"},
+			}},
+		}
+		x := e.Features([]diff.Change{{File: "billing/ledger.go", Functions: []string{"Post", "Total"}}}, runs, tests)
+
+		Expect(x[0][featureEdited]).To(BeZero())
+		Expect(x[0][featureProximity]).To(Equal(1.0))
+		Expect(x[0][featureIdentifiers]).To(Equal(0.5))
+		Expect(x[0][featureCoChange]).To(Equal(0.5))
+		Expect(x[0][featureFailureRate]).To(Equal(0.5))
+		Expect(x[0][featureCoFailureRate]).To(Equal(1.0))
+
+		Expect(x[1][featureProximity]).To(Equal(0.5))
+		Expect(x[1][featureImports]).To(Equal(1.0))
+		Expect(x[1][featureIdentifiers]).To(Equal(0.5))
+		Expect(x[1][featureChurn]).To(BeZero())
+
+		Expect(x[2][featureProximity]).To(BeNumerically("~", 1.0/3))
+		Expect(x[2][featureCoChange]).To(BeZero())
+		Expect(x[2][featureChurn]).To(BeNumerically(">", 0))
+		Expect(x[2][featureFailureRate]).To(BeZero())
+	})
+
+	It("builds examples against earlier runs only", func() {
+		now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		runs := []history.Run{
+			{Time: now, Results: []history.Result{{ID: "billing:TestLedger", Outcome: "pass"}, {ID: "gone:TestGone", Outcome: "fail"}}},
+			{Time: now.Add(-time.Hour), Results: []history.Result{
+				{ID: "billing:TestLedger", Outcome: "fail"}, {ID: "net:TestDial", Outcome: "fail", Quarantined: true},
+			}},
+		}
+		examples := (&Extractor{}).Dataset(runs, tests)
+		Expect(examples).To(HaveLen(2))
+		Expect(examples[0].Failed).To(BeTrue())
+		Expect(examples[0].X[featureFailureRate]).To(BeZero())
+		Expect(examples[1].Failed).To(BeFalse())
+		Expect(examples[1].X[featureFailureRate]).To(Equal(1.0))
+	})
+
+	It("mines co-changes only up to each run's base", func() {
+		later := [][]string{{"billing/ledger.go", "billing/ledger_test.go"}}
+		var mined []string
+		e := &Extractor{
+			Commits: later,
+			CommitsAt: func(rev string) ([][]string, error) {
+				mined = append(mined, rev)
+				if rev == "new" {
+					return later, nil
+				}
+				return nil, nil
+			},
+		}
+		change := []diff.Change{{File: "billing/ledger.go"}}
+		result := []history.Result{{ID: "billing:TestLedger", Outcome: "fail"}}
+		runs := []history.Run{
+			{ID: "a", Base: "old", Changes: change, Results: result},
+			{ID: "b", Base: "old", Changes: change, Results: result},
+			{ID: "c", Base: "new", Changes: change, Results: result},
+		}
+		examples := e.Dataset(runs, tests)
+		Expect(examples).To(HaveLen(3))
+		Expect(examples[0].X[featureCoChange]).To(BeZero())
+		Expect(examples[1].X[featureCoChange]).To(BeZero())
+		Expect(examples[2].X[featureCoChange]).To(Equal(1.0))
+		Expect(mined).To(Equal([]string{"old", "new"}))
+	})
+})
+
+var _ = Describe("Model", func() {
+	example := func(coChange float64, failed bool) Example {
+		x := make([]float64, len(FeatureNames))
+		x[featureCoChange] = coChange
+		return Example{X: x, Failed: failed}
+	}
+
+	It("learns which features predict failures", func() {
+		var examples []Example
+		for i := 0; i < 20; i++ {
+			examples = append(examples, example(0.1, false), example(0.2, false), example(0.3, false))
+		}
+		examples = append(examples, example(0.9, true), example(0.8, true))
+
+		m, err := Fit(examples)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Examples).To(Equal(62))
+		Expect(m.Failures).To(Equal(2))
+		Expect(m.Predict(example(0.9, true).X)).To(BeNumerically(">", 0.5))
+		Expect(m.Predict(example(0.1, false).X)).To(BeNumerically("<", 0.5))
+		Expect(m.Explain(example(0.9, true).X)).To(Equal("co-change 0.90"))
+	})
+
+	It("needs failing and passing results", func() {
+		_, err := Fit([]Example{example(0.1, false)})
+		Expect(err).To(MatchError(ContainSubstring("0 of 1 failing")))
+	})
+
+	It("saves and loads", func() {
+		path := filepath.Join(GinkgoT().TempDir(), ".mango", "model")
+		_, err := Load(path)
+		Expect(err).To(MatchError(ErrNoModel))
+
+		m, err := Fit([]Example{example(0.9, true), example(0.1, false)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Save(path)).To(Succeed())
+		loaded, err := Load(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded).To(Equal(m))
+
+		Expect(os.WriteFile(path, []byte(`{"features":["churn"],"weights":[1]}`), 0o644)).To(Succeed())
+		_, err = Load(path)
+		Expect(err).To(MatchError(ContainSubstring("trained on other features")))
+	})
+})
+
+func TestML(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ML Suite")
+}
@@ -0,0 +1,162 @@
+// Package ml trains and applies a local model that predicts which tests
+// fail for a change. It learns from the run history, the git history and
+// static analysis of the tests, and never sends code anywhere.
+package ml
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPath is where mango train saves the model, relative to the
+// repository.
+const DefaultPath = ".mango/model"
+
+// ErrNoModel is returned by Load when no model has been trained, so a chain
+// can fall back to another strategy.
+var ErrNoModel = errors.New("no trained model, run mango train")
+
+// Training parameters of the logistic regression.
+const (
+	epochs       = 2000
+	learningRate = 1.0
+	l2           = 1e-3
+)
+
+// Model is a logistic regression over the features in FeatureNames.
+type Model struct {
+	Features []string  `json:"features"`
+	Weights  []float64 `json:"weights"`
+	Bias     float64   `json:"bias"`
+	// Trained is when the model was fitted, on Examples test results of
+	// which Failures failed.
+	Trained  time.Time `json:"trained"`
+	Examples int       `json:"examples"`
+	Failures int       `json:"failures"`
+}
+
+// Example is the feature vector of a test on one recorded run and whether it
+// failed.
+type Example struct {
+	X      []float64
+	Failed bool
+}
+
+// Fit trains a model on examples by gradient descent. Failures are rare, so
+// both classes are weighted to count as much in total; a probability of 0.5
+// is then the natural threshold.
+func Fit(examples []Example) (*Model, error) {
+	var failures int
+	for _, ex := range examples {
+		if ex.Failed {
+			failures++
+		}
+	}
+	if failures == 0 || failures == len(examples) {
+		return nil, fmt.Errorf("need both failing and passing results to train, have %d of %d failing", failures, len(examples))
+	}
+	n := float64(len(examples))
+	weightFail := n / (2 * float64(failures))
+	weightPass := n / (2 * float64(len(examples)-failures))
+
+	m := &Model{
+		Features: slices.Clone(FeatureNames),
+		Weights:  make([]float64, len(FeatureNames)),
+		Examples: len(examples),
+		Failures: failures,
+	}
+	grad := make([]float64, len(m.Weights))
+	for range epochs {
+		clear(grad)
+		var gradBias float64
+		for _, ex := range examples {
+			y, c := 0.0, weightPass
+			if ex.Failed {
+				y, c = 1, weightFail
+			}
+			g := c * (m.probability(ex.X) - y)
+			for i, x := range ex.X {
+				grad[i] += g * x
+			}
+			gradBias += g
+		}
+		for i := range m.Weights {
+			m.Weights[i] -= learningRate * (grad[i]/n + l2*m.Weights[i])
+		}
+		m.Bias -= learningRate * gradBias / n
+	}
+	return m, nil
+}
+
+// Predict returns the probability that a test with features x fails.
+func (m *Model) Predict(x []float64) float64 {
+	return m.probability(x)
+}
+
+func (m *Model) probability(x []float64) float64 {
+	z := m.Bias
+	for i, w := range m.Weights {
+		z += w * x[i]
+	}
+	return 1 / (1 + math.Exp(-z))
+}
+
+// Explain names the features that raised the prediction for x the most,
+// e.g. "co-change 0.80, failure rate 0.25".
+func (m *Model) Explain(x []float64) string {
+	type contribution struct {
+		i int
+		v float64
+	}
+	var cs []contribution
+	for i, w := range m.Weights {
+		if v := w * x[i]; v > 0 {
+			cs = append(cs, contribution{i, v})
+		}
+	}
+	sort.SliceStable(cs, func(i, j int) bool { return cs[i].v > cs[j].v })
+	var parts []string
+	for _, c := range cs[:min(len(cs), 3)] {
+		parts = append(parts, fmt.Sprintf("%s %.2f", m.Features[c.i], x[c.i]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Load reads the model at path.
+func Load(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoModel
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Model
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if !slices.Equal(m.Features, FeatureNames) || len(m.Weights) != len(FeatureNames) {
+		return nil, fmt.Errorf("%s was trained on other features, run mango train again", path)
+	}
+	return &m, nil
+}
+
+// Save writes the model to path.
+func (m *Model) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,108 @@
+package orchestrator
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/example/mango/internal/executor"
+	"github.com/example/mango/internal/history"
+	"github.com/example/mango/internal/llmselector"
+)
+
+// defaultDuration is assumed for tests without a recorded duration when no
+// test has one.
+const defaultDuration = time.Second
+
+// prioritize orders the selected tests by failure likelihood, their
+// confidence, and applies TimeBudget and MaxTests. Under a time budget the
+// tests with the most likelihood per second of recorded duration are kept,
+// so the budget buys the most expected failures. The tests left out are
+// returned second.
+func (o Orchestrator) prioritize(selected []llmselector.Selection, duration func(id string) time.Duration) (kept, dropped []llmselector.Selection) {
+	ranked := append([]llmselector.Selection(nil), selected...)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Confidence > ranked[j].Confidence })
+	if o.TimeBudget <= 0 && (o.MaxTests <= 0 || len(ranked) <= o.MaxTests) {
+		return ranked, nil
+	}
+
+	estimate := func(s llmselector.Selection) time.Duration {
+		return max(duration(s.Test.ID()), time.Millisecond)
+	}
+	candidates := ranked
+	if o.TimeBudget > 0 {
+		candidates = append([]llmselector.Selection(nil), ranked...)
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Confidence/estimate(candidates[i]).Seconds() > candidates[j].Confidence/estimate(candidates[j]).Seconds()
+		})
+	}
+	keep := map[string]bool{}
+	var spent time.Duration
+	for _, s := range candidates {
+		if o.MaxTests > 0 && len(keep) == o.MaxTests {
+			break
+		}
+		d := estimate(s)
+		if o.TimeBudget > 0 && spent+d > o.TimeBudget {
+			continue
+		}
+		keep[s.Test.ID()] = true
+		spent += d
+	}
+	for _, s := range ranked {
+		if keep[s.Test.ID()] {
+			kept = append(kept, s)
+		} else {
+			dropped = append(dropped, s)
+		}
+	}
+	return kept, dropped
+}
+
+// durations returns the average recorded duration of a test by ID. Tests
+// that never ran take the median of the others. Planned tests take their
+// planned duration, so every shard of a plan uses the same numbers.
+func (o Orchestrator) durations() func(id string) time.Duration {
+	var runs []history.Run
+	if o.History != nil {
+		var err error
+		if runs, err = o.History.List(); err != nil {
+			log.Printf("history: %v", err)
+		}
+	}
+	total := map[string]time.Duration{}
+	count := map[string]int{}
+	for _, r := range runs {
+		for _, res := range r.Results {
+			if res.Outcome == executor.OutcomeSkip {
+				continue
+			}
+			total[res.ID] += time.Duration(res.Duration)
+			count[res.ID]++
+		}
+	}
+	avg := map[string]time.Duration{}
+	var all []time.Duration
+	for id, d := range total {
+		avg[id] = d / time.Duration(count[id])
+		all = append(all, avg[id])
+	}
+	fallback := defaultDuration
+	if len(all) > 0 {
+		sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+		fallback = all[len(all)/2]
+	}
+	var planned map[string]time.Duration
+	if o.Plan != nil {
+		planned = o.Plan.Durations()
+	}
+	return func(id string) time.Duration {
+		if d, ok := planned[id]; ok {
+			return d
+		}
+		if d, ok := avg[id]; ok {
+			return d
+		}
+		return fallback
+	}
+}
@@ -2,12 +2,19 @@ package orchestrator
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/example/mango/internal/diff"
 	"github.com/example/mango/internal/executor"
+	"github.com/example/mango/internal/history"
 	"github.com/example/mango/internal/llmselector"
+	"github.com/example/mango/internal/plan"
+	"github.com/example/mango/internal/quarantine"
 	"github.com/example/mango/internal/testmeta"
 )
 
@@ -16,41 +23,201 @@ type Orchestrator struct {
 	Selector llmselector.Selector
 	Mode     string // auto, go, ginkgo
 	DryRun   bool
+
+	// History records every run that executes tests when set, with the
+	// strategy and model that made the selection.
+	History *history.Store
+
+	// Shadow runs the unselected tests after the selected ones. Failures
+	// among them are escapes: failures the selection would have missed.
+	Shadow bool
+	// Prompts returns the prompts sent during selection, recorded with
+	// escapes. Optional.
+	Prompts func() string
+
+	// Retries reruns failed tests this many times before they count as
+	// failures.
+	Retries int
+	// Quarantine lists tests that run but whose failures do not fail the
+	// run. They are reported separately.
+	Quarantine *quarantine.List
+
+	// TimeBudget and MaxTests cap the selected tests that run, keeping those
+	// most likely to fail for their recorded duration. Zero means no cap.
+	// The tests run most likely to fail first either way.
+	TimeBudget time.Duration
+	MaxTests   int
+	// FailFast stops at the first failing test.
+	FailFast bool
+
+	// Plan, when set, replaces diff analysis and selection: its tests run
+	// in the modes it names.
+	Plan *plan.Plan
+	// Shard and Shards run only shard Shard, counted from 1, of the
+	// selection split into Shards balanced by duration.
+	Shard  int
+	Shards int
 }
 
 // Run performs the end-to-end workflow.
 func (o Orchestrator) Run(ctx context.Context, diffRange string) error {
-	changes, err := diff.AnalyzeDiff(diffRange)
+	changes, tests, selected, err := o.selection(ctx, diffRange)
 	if err != nil {
 		return err
 	}
+	if o.Plan != nil {
+		diffRange = o.Plan.Range
+		o.Mode = "auto"
+	}
+	duration := o.durations()
+	// Shadow runs leave out the whole selection, not only this shard's.
+	all := selected
+	if o.Shards > 1 {
+		total := len(selected)
+		selected = plan.Shard(selected, o.Shard, o.Shards, duration)
+		fmt.Printf("Shard %d/%d: %d of %d selected tests\n", o.Shard, o.Shards, len(selected), total)
+	}
+
+	fmt.Println("Selected tests:")
+	for _, s := range selected {
+		if o.DryRun && s.Reason != "" {
+			fmt.Printf("- %s (%s) [%.2f]%s %s%s\n", s.Test.Name, s.Test.File, s.Confidence, votes(s), s.Reason, mustRun(s.Rules))
+			continue
+		}
+		fmt.Printf("- %s (%s)\n", s.Test.Name, s.Test.File)
+	}
+	if o.DryRun {
+		return nil
+	}
+	selected, dropped := o.prioritize(selected, duration)
+	if len(dropped) > 0 {
+		fmt.Printf("Over the budget, not run (%d of %d):\n", len(dropped), len(dropped)+len(selected))
+		for _, s := range dropped {
+			fmt.Printf("- %s (%s)\n", s.Test.Name, s.Test.File)
+		}
+	}
+
+	start := time.Now()
+	results, err := o.Execute(ctx, selected)
+	var escapes []string
+	// Failing fast, a failure ends the run before the shadow run.
+	if o.Shadow && !(o.FailFast && err != nil) {
+		var shadowErr error
+		escapes, shadowErr = o.shadow(ctx, tests, all, dropped, duration, &results)
+		err = errors.Join(err, shadowErr)
+	}
+	report(results)
+	if o.History != nil {
+		run := o.record(diffRange, changes, tests, selected, results, time.Since(start), err)
+		if o.Shadow {
+			o.recordEscapes(&run, diffRange, escapes)
+		}
+		if err := o.History.Save(&run); err != nil {
+			log.Printf("history: could not record run: %v", err)
+		}
+	}
+	return err
+}
 
+// selection analyses the diff and selects tests, or takes both from Plan.
+func (o Orchestrator) selection(ctx context.Context, diffRange string) ([]diff.Change, []testmeta.Metadata, []llmselector.Selection, error) {
+	if o.Plan != nil {
+		tests, err := testmeta.Extract()
+		return o.Plan.Changes, tests, o.Plan.Selections(), err
+	}
+	changes, err := diff.AnalyzeDiff(diffRange)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	tests, err := testmeta.Extract()
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
-
 	selected, err := o.Selector.Select(ctx, changes, tests)
+	return changes, tests, selected, err
+}
+
+// NewPlan analyses the diff and selects tests as Run does, and returns the
+// selection as a plan instead of running it.
+func (o Orchestrator) NewPlan(ctx context.Context, diffRange string) (*plan.Plan, error) {
+	changes, _, selected, err := o.selection(ctx, diffRange)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	p := plan.New(selected, o.Mode, o.durations())
+	p.Created = time.Now().UTC().Truncate(time.Second)
+	p.Range = diffRange
+	p.Provider, p.Model = answeredBy(selected)
+	p.Changes = changes
+	if p.Base, p.Head, err = diff.Commits(diffRange); err != nil {
+		return nil, err
 	}
+	return p, nil
+}
 
-	fmt.Println("Selected tests:")
-	for _, t := range selected {
-		fmt.Printf("- %s (%s)\n", t.Name, t.File)
+// shadow runs the tests that were not selected, appends their results and
+// returns the IDs of those that failed. selected is the whole selection; a
+// sharded run shadows its share of the rest. dropped are this run's selected
+// tests left out by the budget, which nothing else runs.
+func (o Orchestrator) shadow(ctx context.Context, tests []testmeta.Metadata, selected, dropped []llmselector.Selection, duration func(id string) time.Duration, results *[]history.Result) ([]string, error) {
+	picked := map[string]bool{}
+	for _, s := range selected {
+		picked[s.Test.ID()] = true
 	}
-	if o.DryRun {
-		return nil
+	var rest []llmselector.Selection
+	for _, t := range tests {
+		if !picked[t.ID()] {
+			rest = append(rest, llmselector.Selection{Test: t, Source: "shadow"})
+		}
+	}
+	if o.Shards > 1 {
+		rest = plan.Shard(rest, o.Shard, o.Shards, duration)
+	}
+	for _, s := range dropped {
+		rest = append(rest, llmselector.Selection{Test: s.Test, Source: "shadow"})
+	}
+	fmt.Printf("Shadow run: %d unselected or unrun tests\n", len(rest))
+	shadowResults, err := o.Execute(ctx, rest)
+	*results = append(*results, shadowResults...)
+
+	var escapes []string
+	for _, r := range shadowResults {
+		if r.Outcome == executor.OutcomeFail && !r.Quarantined {
+			escapes = append(escapes, r.ID)
+		}
+	}
+	if len(escapes) > 0 {
+		fmt.Println("Escaped failures, not selected:")
+		for _, id := range escapes {
+			fmt.Printf("- %s\n", id)
+		}
 	}
+	return escapes, err
+}
 
+// Execute runs the selected tests package by package, in the order the
+// packages first appear, and returns the results of the tests that ran.
+// Every package runs even when an earlier one fails, unless FailFast is set;
+// the failures are returned together.
+func (o Orchestrator) Execute(ctx context.Context, selected []llmselector.Selection) ([]history.Result, error) {
 	// group by package
+	var order []string
 	packages := map[string][]testmeta.Metadata{}
-	for _, t := range selected {
-		pkg := filepath.Dir(t.File)
-		packages[pkg] = append(packages[pkg], t)
+	for _, s := range selected {
+		pkg := filepath.Dir(s.Test.File)
+		if _, ok := packages[pkg]; !ok {
+			order = append(order, pkg)
+		}
+		packages[pkg] = append(packages[pkg], s.Test)
 	}
 
-	for pkg, metas := range packages {
+	var results []history.Result
+	var errs []error
+	for _, pkg := range order {
+		if o.FailFast && len(errs) > 0 {
+			break
+		}
+		metas := packages[pkg]
 		names := make([]string, len(metas))
 		ginkgo := false
 		for i, m := range metas {
@@ -69,19 +236,149 @@ func (o Orchestrator) Run(ctx context.Context, diffRange string) error {
 			}
 		}
 
+		var ran []executor.Result
+		var err error
 		switch mode {
 		case "go":
-			if err := executor.RunGoTests(ctx, pkg, names); err != nil {
-				return err
-			}
+			ran, err = executor.RunGoTests(ctx, pkg, names, o.Retries, o.FailFast)
 		case "ginkgo":
-			if err := executor.RunGinkgo(ctx, pkg, names); err != nil {
-				return err
-			}
+			ran, err = executor.RunGinkgo(ctx, pkg, names, o.Retries, o.FailFast)
 		default:
-			return fmt.Errorf("unknown mode %s", mode)
+			return results, fmt.Errorf("unknown mode %s", mode)
+		}
+		// A package whose only failures are quarantined does not fail the
+		// run.
+		failed, quarantinedOnly := false, true
+		for _, r := range ran {
+			res := history.Result{
+				ID:       testmeta.Metadata{Name: r.Test, File: metas[0].File}.ID(),
+				Outcome:  r.Outcome,
+				Duration: history.Duration(r.Duration),
+			}
+			res.Quarantined = o.Quarantine.Contains(res.ID)
+			if r.Attempts > 1 {
+				res.Attempts = r.Attempts
+			}
+			if res.Outcome == executor.OutcomeFail {
+				failed = true
+				quarantinedOnly = quarantinedOnly && res.Quarantined
+			}
+			results = append(results, res)
+		}
+		if err != nil && failed && quarantinedOnly {
+			err = nil
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", pkg, err))
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// report lists the tests that passed only on a retry and the quarantined
+// tests, whose outcome does not count.
+func report(results []history.Result) {
+	var flaky, quarantined []history.Result
+	for _, r := range results {
+		switch {
+		case r.Quarantined:
+			quarantined = append(quarantined, r)
+		case r.Outcome == executor.OutcomeFlaky:
+			flaky = append(flaky, r)
+		}
+	}
+	if len(flaky) > 0 {
+		fmt.Println("Flaky tests, passed on a retry:")
+		for _, r := range flaky {
+			fmt.Printf("- %s (%d attempts)\n", r.ID, r.Attempts)
+		}
+	}
+	if len(quarantined) > 0 {
+		fmt.Println("Quarantined tests, not failing the run:")
+		for _, r := range quarantined {
+			fmt.Printf("- %s: %s\n", r.ID, r.Outcome)
+		}
+	}
+}
+
+// record describes the run for the history.
+func (o Orchestrator) record(diffRange string, changes []diff.Change, tests []testmeta.Metadata, selected []llmselector.Selection, results []history.Result, took time.Duration, runErr error) history.Run {
+	run := history.Run{
+		Range:    diffRange,
+		Changes:  changes,
+		Results:  results,
+		Duration: history.Duration(took.Round(time.Millisecond)),
+	}
+	run.Provider, run.Model = answeredBy(selected)
+	var err error
+	if o.Plan != nil {
+		run.Base, run.Head = o.Plan.Base, o.Plan.Head
+	} else if run.Base, run.Head, err = diff.Commits(diffRange); err != nil {
+		log.Printf("history: %v", err)
+	}
+	if run.Tree, err = diff.Fingerprint(); err != nil {
+		log.Printf("history: %v", err)
+	}
+	for _, t := range tests {
+		run.Candidates = append(run.Candidates, t.ID())
+	}
+	for _, s := range selected {
+		run.Selected = append(run.Selected, history.Selected{
+			ID: s.Test.ID(), Reason: s.Reason, Confidence: s.Confidence, Source: s.Source, Rules: s.Rules,
+			Votes: s.Votes, Voters: s.Voters,
+		})
+	}
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+	return run
+}
+
+// recordEscapes marks run as a shadow run and keeps the diff and prompts
+// when failures escaped the selection.
+func (o Orchestrator) recordEscapes(run *history.Run, diffRange string, escapes []string) {
+	run.Shadow = true
+	run.Escapes = escapes
+	if len(escapes) == 0 {
+		return
+	}
+	patch, err := diff.Patch(diffRange)
+	if err != nil {
+		log.Printf("history: %v", err)
+	}
+	run.Diff = patch
+	if o.Prompts != nil {
+		run.Prompt = o.Prompts()
+	}
+}
+
+// answeredBy returns the strategy that made the selection, as stamped on it
+// by the fallback chain: the provider and resolved model for an LLM, e.g.
+// openai and gpt-4o, otherwise the strategy name, e.g. history. Tests the
+// safety net added are not the strategy's answer.
+func answeredBy(selected []llmselector.Selection) (provider, model string) {
+	for _, s := range selected {
+		if s.Source != "" && s.Source != llmselector.SourceSafetyNet {
+			provider, model, _ = strings.Cut(s.Source, "/")
+			return provider, model
 		}
 	}
+	return "", ""
+}
 
-	return nil
+// mustRun renders the safety-net rules that pulled a test in.
+func mustRun(rules []string) string {
+	if len(rules) == 0 {
+		return ""
+	}
+	return " {must-run: " + strings.Join(rules, ", ") + "}"
+}
+
+// votes renders how many ensemble members agreed on a test.
+func votes(s llmselector.Selection) string {
+	if s.Voters < 2 {
+		return ""
+	}
+	return fmt.Sprintf(" [votes %d/%d]", s.Votes, s.Voters)
 }
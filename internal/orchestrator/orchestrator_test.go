@@ -7,11 +7,15 @@ import (
 	"context"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/example/mango/internal/history"
+	"github.com/example/mango/internal/llmselector"
 	"github.com/example/mango/internal/llmselector/llmselectorfakes"
 	"github.com/example/mango/internal/testmeta"
 )
@@ -37,14 +41,162 @@ var _ = Describe("Orchestrator", func() {
 
 		meta, err := testmeta.Extract()
 		Expect(err).NotTo(HaveOccurred())
+		selected := make([]llmselector.Selection, len(meta))
+		for i, m := range meta {
+			selected[i] = llmselector.Selection{Test: m, Reason: "changed", Confidence: 1}
+		}
 		sel := &llmselectorfakes.FakeSelector{}
-		sel.SelectReturns(meta, nil)
+		sel.SelectReturns(selected, nil)
 		orch := Orchestrator{Selector: sel, Mode: "auto", DryRun: true}
 		err = orch.Run(context.Background(), "HEAD~1")
 		Expect(err).NotTo(HaveOccurred())
 	})
 })
 
+var _ = Describe("Shadow runs", func() {
+	It("records failing unselected tests as escapes", func() {
+		if _, err := exec.LookPath("git"); err != nil {
+			Skip("git not installed")
+		}
+		dir := GinkgoT().TempDir()
+		os.Chdir(dir)
+		exec.Command("git", "init").Run()
+		exec.Command("git", "config", "user.email", "a@b.c").Run()
+		exec.Command("git", "config", "user.name", "t").Run()
+		os.WriteFile("go.mod", []byte("module example.com/test\ngo 1.23.0"), 0o644)
+		os.WriteFile("foo.go", []byte("package main\nfunc Add(a,b int) int { return a+b }"), 0o644)
+		os.WriteFile("foo_test.go", []byte("package main\nimport \"testing\"\nfunc TestAdd(t *testing.T){ if Add(1,1) != 2 { t.Fail() } }\nfunc TestOther(t *testing.T){}"), 0o644)
+		exec.Command("git", "add", ".").Run()
+		exec.Command("git", "commit", "-m", "init").Run()
+		os.WriteFile("foo.go", []byte("package main\nfunc Add(a,b int) int { return a+b+1 }"), 0o644)
+		exec.Command("git", "commit", "-am", "update").Run()
+
+		meta, err := testmeta.Extract()
+		Expect(err).NotTo(HaveOccurred())
+		var other llmselector.Selection
+		for _, m := range meta {
+			if m.Name == "TestOther" {
+				other = llmselector.Selection{Test: m, Reason: "guess", Confidence: 0.5, Source: "openai/gpt-4o-mini"}
+			}
+		}
+		sel := &llmselectorfakes.FakeSelector{}
+		sel.SelectReturns([]llmselector.Selection{other}, nil)
+		store := history.Open(filepath.Join(dir, ".mango", "history"))
+		orch := Orchestrator{Selector: sel, Mode: "go", History: store, Shadow: true, Prompts: func() string { return "the prompt" }}
+		Expect(orch.Run(context.Background(), "HEAD~1")).To(HaveOccurred())
+
+		runs, err := store.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runs).To(HaveLen(1))
+		Expect(runs[0].Shadow).To(BeTrue())
+		Expect([]string{runs[0].Provider, runs[0].Model}).To(Equal([]string{"openai", "gpt-4o-mini"}))
+		Expect(runs[0].Escapes).To(Equal([]string{".:TestAdd"}))
+		Expect(runs[0].Prompt).To(Equal("the prompt"))
+		Expect(runs[0].Diff).To(ContainSubstring("+func Add(a,b int) int { return a+b+1 }"))
+	})
+})
+
+var _ = Describe("Running the selection", func() {
+	var store *history.Store
+	var sel *llmselectorfakes.FakeSelector
+
+	BeforeEach(func() {
+		if _, err := exec.LookPath("git"); err != nil {
+			Skip("git not installed")
+		}
+		dir := GinkgoT().TempDir()
+		os.Chdir(dir)
+		exec.Command("git", "init").Run()
+		exec.Command("git", "config", "user.email", "a@b.c").Run()
+		exec.Command("git", "config", "user.name", "t").Run()
+		os.WriteFile("go.mod", []byte("module example.com/test\ngo 1.23.0"), 0o644)
+		os.Mkdir("a", 0o755)
+		os.Mkdir("b", 0o755)
+		os.WriteFile("a/a_test.go", []byte("package a\nimport \"testing\"\nfunc TestA(t *testing.T){ t.Fail() }\nfunc TestC(t *testing.T){}"), 0o644)
+		os.WriteFile("b/b_test.go", []byte("package b\nimport \"testing\"\nfunc TestB(t *testing.T){}"), 0o644)
+		exec.Command("git", "add", ".").Run()
+		exec.Command("git", "commit", "-m", "init").Run()
+		os.WriteFile("b/b.go", []byte("package b"), 0o644)
+		exec.Command("git", "add", ".").Run()
+		exec.Command("git", "commit", "-m", "update").Run()
+
+		meta, err := testmeta.Extract()
+		Expect(err).NotTo(HaveOccurred())
+		confidence := map[string]float64{"TestA": 0.9, "TestB": 0.5, "TestC": 0.1}
+		var selected []llmselector.Selection
+		for _, m := range meta {
+			selected = append(selected, llmselector.Selection{Test: m, Reason: "guess", Confidence: confidence[m.Name]})
+		}
+		sel = &llmselectorfakes.FakeSelector{}
+		sel.SelectReturns(selected, nil)
+		store = history.Open(filepath.Join(dir, ".mango", "history"))
+	})
+
+	ran := func() []string {
+		runs, err := store.List()
+		Expect(err).NotTo(HaveOccurred())
+		var ids []string
+		for _, r := range runs[0].Results {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	It("keeps the most likely failures per second of recorded duration", func() {
+		Expect(store.Save(&history.Run{Results: []history.Result{
+			{ID: "a:TestA", Outcome: "fail", Duration: history.Duration(10 * time.Second)},
+			{ID: "a:TestC", Outcome: "pass", Duration: history.Duration(time.Second)},
+			{ID: "b:TestB", Outcome: "pass", Duration: history.Duration(time.Second)},
+		}})).To(Succeed())
+
+		orch := Orchestrator{Selector: sel, Mode: "go", History: store, TimeBudget: 3 * time.Second}
+		Expect(orch.Run(context.Background(), "HEAD~1")).To(Succeed())
+		Expect(ran()).To(Equal([]string{"b:TestB", "a:TestC"}))
+	})
+
+	It("shadows the tests the budget left out", func() {
+		Expect(store.Save(&history.Run{Results: []history.Result{
+			{ID: "a:TestA", Outcome: "fail", Duration: history.Duration(10 * time.Second)},
+			{ID: "a:TestC", Outcome: "pass", Duration: history.Duration(time.Second)},
+			{ID: "b:TestB", Outcome: "pass", Duration: history.Duration(time.Second)},
+		}})).To(Succeed())
+
+		orch := Orchestrator{Selector: sel, Mode: "go", History: store, TimeBudget: 3 * time.Second, Shadow: true}
+		orch.Run(context.Background(), "HEAD~1")
+		Expect(ran()).To(Equal([]string{"b:TestB", "a:TestC", "a:TestA"}))
+		runs, err := store.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runs[0].Escapes).To(Equal([]string{"a:TestA"}))
+	})
+
+	It("runs the likeliest failures first and stops at the first failure", func() {
+		orch := Orchestrator{Selector: sel, Mode: "go", History: store, MaxTests: 2, FailFast: true}
+		Expect(orch.Run(context.Background(), "HEAD~1")).To(HaveOccurred())
+		Expect(ran()).To(Equal([]string{"a:TestA"}))
+	})
+
+	It("runs a shard of a plan without selecting again", func() {
+		p, err := Orchestrator{Selector: sel, Mode: "auto"}.NewPlan(context.Background(), "HEAD~1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Tests()).To(Equal(3))
+		Expect(p.Base).NotTo(BeEmpty())
+
+		orch := Orchestrator{Plan: p, History: store, Shard: 2, Shards: 2}
+		Expect(orch.Run(context.Background(), "")).To(Succeed())
+		Expect(sel.SelectCallCount()).To(Equal(1))
+		Expect(ran()).To(Equal([]string{"a:TestC"}))
+	})
+
+	It("does not shadow tests other shards selected", func() {
+		p, err := Orchestrator{Selector: sel, Mode: "auto"}.NewPlan(context.Background(), "HEAD~1")
+		Expect(err).NotTo(HaveOccurred())
+
+		orch := Orchestrator{Plan: p, History: store, Shard: 2, Shards: 2, Shadow: true}
+		Expect(orch.Run(context.Background(), "")).To(Succeed())
+		Expect(ran()).To(Equal([]string{"a:TestC"}))
+	})
+})
+
 func TestOrchestrator(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Orchestrator Suite")
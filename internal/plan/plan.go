@@ -0,0 +1,220 @@
+// Package plan stores a test selection as an artifact, so tests can be
+// selected once and run elsewhere, and splits selections into shards for
+// parallel CI runners.
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/history"
+	"github.com/example/mango/internal/llmselector"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// Version is the plan format written by Save. Load rejects other versions.
+const Version = 1
+
+// Execution modes of a package.
+const (
+	ModeGo     = "go"
+	ModeGinkgo = "ginkgo"
+)
+
+// Plan is a test selection together with the inputs it was made from.
+type Plan struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Range is the diff range as given; Base and Head are the commits it
+	// resolved to. Head is empty when the diff was against the working tree.
+	Range    string        `json:"range"`
+	Base     string        `json:"base,omitempty"`
+	Head     string        `json:"head,omitempty"`
+	Provider string        `json:"provider,omitempty"`
+	Model    string        `json:"model,omitempty"`
+	Changes  []diff.Change `json:"changes"`
+	Packages []Package     `json:"packages"`
+}
+
+// Package holds the selected tests of one package and how they run.
+type Package struct {
+	Dir   string `json:"dir"`
+	Mode  string `json:"mode"`
+	Tests []Test `json:"tests"`
+}
+
+// Test is a selected test. Duration is its estimated duration at planning
+// time, so every shard is computed from the same numbers.
+type Test struct {
+	ID         string           `json:"id"`
+	Name       string           `json:"name"`
+	File       string           `json:"file"`
+	Reason     string           `json:"reason,omitempty"`
+	Confidence float64          `json:"confidence"`
+	Source     string           `json:"source,omitempty"`
+	Rules      []string         `json:"rules,omitempty"`
+	Duration   history.Duration `json:"duration"`
+}
+
+// New groups selected by package, in the order the packages first appear.
+// mode is auto, go or ginkgo; under auto a package with a Ginkgo test runs
+// with ginkgo. duration estimates each test by ID.
+func New(selected []llmselector.Selection, mode string, duration func(id string) time.Duration) *Plan {
+	p := &Plan{Version: Version}
+	index := map[string]int{}
+	for _, s := range selected {
+		dir := filepath.ToSlash(filepath.Dir(s.Test.File))
+		i, ok := index[dir]
+		if !ok {
+			i = len(p.Packages)
+			index[dir] = i
+			p.Packages = append(p.Packages, Package{Dir: dir, Mode: mode})
+		}
+		pkg := &p.Packages[i]
+		if mode == "auto" {
+			switch {
+			case s.Test.Ginkgo:
+				pkg.Mode = ModeGinkgo
+			case pkg.Mode == "auto":
+				pkg.Mode = ModeGo
+			}
+		}
+		pkg.Tests = append(pkg.Tests, Test{
+			ID: s.Test.ID(), Name: s.Test.Name, File: filepath.ToSlash(s.Test.File),
+			Reason: s.Reason, Confidence: s.Confidence, Source: s.Source, Rules: s.Rules,
+			Duration: history.Duration(duration(s.Test.ID())),
+		})
+	}
+	return p
+}
+
+// Selections returns the planned tests as a selection. Tests of ginkgo
+// packages are marked as Ginkgo tests, so they run with ginkgo in auto mode.
+func (p *Plan) Selections() []llmselector.Selection {
+	var selected []llmselector.Selection
+	for _, pkg := range p.Packages {
+		for _, t := range pkg.Tests {
+			selected = append(selected, llmselector.Selection{
+				Test:   testmeta.Metadata{Name: t.Name, File: filepath.FromSlash(t.File), Ginkgo: pkg.Mode == ModeGinkgo},
+				Reason: t.Reason, Confidence: t.Confidence, Source: t.Source, Rules: t.Rules,
+			})
+		}
+	}
+	return selected
+}
+
+// Durations returns the planned duration of every test by ID.
+func (p *Plan) Durations() map[string]time.Duration {
+	out := map[string]time.Duration{}
+	for _, pkg := range p.Packages {
+		for _, t := range pkg.Tests {
+			out[t.ID] = time.Duration(t.Duration)
+		}
+	}
+	return out
+}
+
+// Tests counts the planned tests.
+func (p *Plan) Tests() int {
+	n := 0
+	for _, pkg := range p.Packages {
+		n += len(pkg.Tests)
+	}
+	return n
+}
+
+// Load reads the plan at path.
+func Load(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if p.Version != Version {
+		return nil, fmt.Errorf("%s: plan version %d is not supported, want %d", path, p.Version, Version)
+	}
+	return &p, nil
+}
+
+// Save writes the plan to path.
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// ParseShard parses a shard written as i/n, e.g. 3/8, where i counts from 1.
+func ParseShard(s string) (index, count int, err error) {
+	i, n, ok := strings.Cut(s, "/")
+	if ok {
+		index, err = strconv.Atoi(i)
+		if err == nil {
+			count, err = strconv.Atoi(n)
+		}
+	}
+	if !ok || err != nil || count < 1 || index < 1 || index > count {
+		return 0, 0, fmt.Errorf("invalid shard %q, want i/n with 1 <= i <= n", s)
+	}
+	return index, count, nil
+}
+
+// Shard returns the tests of shard index out of count. Tests are dealt
+// longest first to the shard with the least total duration, ties going to
+// the lower shard, so every runner computes the same split from the same
+// durations. With equal durations the shards balance by count. The order of
+// selected is kept within a shard.
+func Shard(selected []llmselector.Selection, index, count int, duration func(id string) time.Duration) []llmselector.Selection {
+	if count <= 1 {
+		return selected
+	}
+	order := make([]int, len(selected))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		sa, sb := selected[order[a]], selected[order[b]]
+		da, db := duration(sa.Test.ID()), duration(sb.Test.ID())
+		if da != db {
+			return da > db
+		}
+		return sa.Test.ID() < sb.Test.ID()
+	})
+	totals := make([]time.Duration, count)
+	assigned := make([]int, len(selected))
+	for _, i := range order {
+		least := 0
+		for s := 1; s < count; s++ {
+			if totals[s] < totals[least] {
+				least = s
+			}
+		}
+		assigned[i] = least
+		// Every test weighs at least a nanosecond, so zero durations
+		// still spread out.
+		totals[least] += max(duration(selected[i].Test.ID()), 1)
+	}
+	var out []llmselector.Selection
+	for i, s := range selected {
+		if assigned[i] == index-1 {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package plan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/example/mango/internal/llmselector"
+	"github.com/example/mango/internal/testmeta"
+)
+
+func selection(file, name string, ginkgo bool) llmselector.Selection {
+	return llmselector.Selection{Test: testmeta.Metadata{Name: name, File: file, Ginkgo: ginkgo}, Reason: "covers " + name, Confidence: 0.5}
+}
+
+func ids(selected []llmselector.Selection) []string {
+	var out []string
+	for _, s := range selected {
+		out = append(out, s.Test.ID())
+	}
+	return out
+}
+
+var _ = Describe("Plan", func() {
+	selected := []llmselector.Selection{
+		selection("api/api_test.go", "TestGet", false),
+		selection("db/db_test.go", "TestQuery", false),
+		selection("api/api_test.go", "Handlers", true),
+	}
+	duration := func(id string) time.Duration { return time.Second }
+
+	It("groups tests by package and resolves the mode", func() {
+		p := New(selected, "auto", duration)
+		Expect(p.Version).To(Equal(Version))
+		Expect(p.Packages).To(HaveLen(2))
+		Expect(p.Packages[0].Dir).To(Equal("api"))
+		Expect(p.Packages[0].Mode).To(Equal(ModeGinkgo))
+		Expect(p.Packages[0].Tests).To(HaveLen(2))
+		Expect(p.Packages[1].Mode).To(Equal(ModeGo))
+		Expect(p.Tests()).To(Equal(3))
+		Expect(p.Durations()).To(HaveKeyWithValue("db:TestQuery", time.Second))
+
+		Expect(New(selected, "go", duration).Packages[0].Mode).To(Equal(ModeGo))
+	})
+
+	It("saves, loads and returns the selection", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "plan.json")
+		Expect(New(selected, "auto", duration).Save(path)).To(Succeed())
+		p, err := Load(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		back := p.Selections()
+		Expect(ids(back)).To(Equal([]string{"api:TestGet", "api:Handlers", "db:TestQuery"}))
+		Expect(back[0].Test.Ginkgo).To(BeTrue())
+		Expect(back[0].Reason).To(Equal("covers TestGet"))
+		Expect(back[2].Test.Ginkgo).To(BeFalse())
+	})
+
+	It("rejects other versions", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "plan.json")
+		Expect(os.WriteFile(path, []byte(`{"version": 2}`), 0o644)).To(Succeed())
+		_, err := Load(path)
+		Expect(err).To(MatchError(ContainSubstring("plan version 2 is not supported")))
+	})
+})
+
+var _ = Describe("Shard", func() {
+	It("parses i/n", func() {
+		i, n, err := ParseShard("3/8")
+		Expect(err).NotTo(HaveOccurred())
+		Expect([]int{i, n}).To(Equal([]int{3, 8}))
+		for _, bad := range []string{"3", "0/2", "3/2", "a/2", "1/0"} {
+			_, _, err := ParseShard(bad)
+			Expect(err).To(HaveOccurred(), bad)
+		}
+	})
+
+	It("balances shards by duration", func() {
+		durations := map[string]time.Duration{"a:TestLong": 6 * time.Second, "a:TestB": 3 * time.Second, "b:TestC": 2 * time.Second, "b:TestD": time.Second}
+		duration := func(id string) time.Duration { return durations[id] }
+		selected := []llmselector.Selection{
+			selection("a/a_test.go", "TestB", false),
+			selection("b/b_test.go", "TestC", false),
+			selection("a/a_test.go", "TestLong", false),
+			selection("b/b_test.go", "TestD", false),
+		}
+		Expect(ids(Shard(selected, 1, 2, duration))).To(Equal([]string{"a:TestLong"}))
+		Expect(ids(Shard(selected, 2, 2, duration))).To(Equal([]string{"a:TestB", "b:TestC", "b:TestD"}))
+
+		reversed := []llmselector.Selection{selected[3], selected[2], selected[1], selected[0]}
+		Expect(ids(Shard(reversed, 2, 2, duration))).To(ConsistOf("a:TestB", "b:TestC", "b:TestD"))
+	})
+
+	It("balances by count without durations", func() {
+		var selected []llmselector.Selection
+		for _, name := range []string{"TestA", "TestB", "TestC", "TestD", "TestE"} {
+			selected = append(selected, selection("a/a_test.go", name, false))
+		}
+		none := func(string) time.Duration { return 0 }
+		Expect(Shard(selected, 1, 3, none)).To(HaveLen(2))
+		Expect(Shard(selected, 2, 3, none)).To(HaveLen(2))
+		Expect(Shard(selected, 3, 3, none)).To(HaveLen(1))
+		Expect(Shard(selected, 1, 1, none)).To(HaveLen(5))
+	})
+})
+
+func TestPlan(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Plan Suite")
+}
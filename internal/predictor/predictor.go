@@ -6,67 +6,32 @@ import (
 	"fmt"
 	"strings"
 
-	openai "github.com/sashabaranov/go-openai"
-
+	"github.com/example/mango/internal/llm"
+	"github.com/example/mango/internal/prompt"
 	"github.com/example/mango/internal/testmeta"
 )
 
-// Client abstracts the LLM.
-type Client interface {
-	ChatCompletion(ctx context.Context, prompt string) (string, error)
-}
-
-// OpenAIClient implements Client using OpenAI.
-type OpenAIClient struct{ client *openai.Client }
-
-func NewOpenAIClient(token string) *OpenAIClient {
-	if token == "" {
-		return nil
-	}
-	return &OpenAIClient{client: openai.NewClient(token)}
-}
-
-func (c *OpenAIClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
-	req := openai.ChatCompletionRequest{
-		Model:     openai.GPT3Dot5Turbo,
-		Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: prompt}},
-		MaxTokens: 512,
-	}
-	resp, err := c.client.CreateChatCompletion(ctx, req)
-	if err != nil {
-		return "", err
-	}
-	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("empty response")
-	}
-	return resp.Choices[0].Message.Content, nil
-}
-
 // Predictor forecasts future failing tests from planned changes.
-type Predictor struct{ Client Client }
+type Predictor struct{ Client llm.Client }
 
-func New(client Client) *Predictor { return &Predictor{Client: client} }
+func New(client llm.Client) *Predictor { return &Predictor{Client: client} }
 
 // Predict returns tests likely to fail given an upcoming change description.
 func (p Predictor) Predict(ctx context.Context, description string, tests []testmeta.Metadata) ([]string, error) {
 	if p.Client == nil {
 		return nil, fmt.Errorf("no client configured")
 	}
-	var b strings.Builder
-	b.WriteString("Planned change:\n")
-	b.WriteString(description)
-	b.WriteString("\nAvailable tests:\n")
-	for _, t := range tests {
-		b.WriteString("- " + t.Name + "\n")
+	text, err := prompt.Render(prompt.Predict, prompt.PredictionData{Plan: description, Tests: tests})
+	if err != nil {
+		return nil, err
 	}
-	b.WriteString("\nWhich tests are most likely to fail? Respond with a JSON array of test names.")
-	resp, err := p.Client.ChatCompletion(ctx, b.String())
+	resp, err := p.Client.ChatCompletion(ctx, llm.Request{Prompt: text})
 	if err != nil {
 		return nil, err
 	}
 	var names []string
-	if err := json.Unmarshal([]byte(resp), &names); err != nil {
-		lines := strings.Split(resp, "\n")
+	if err := json.Unmarshal([]byte(resp.Text), &names); err != nil {
+		lines := strings.Split(resp.Text, "\n")
 		for _, l := range lines {
 			l = strings.TrimSpace(l)
 			if l != "" {
@@ -7,21 +7,20 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/example/mango/internal/llm"
+	"github.com/example/mango/internal/llm/llmfakes"
 	"github.com/example/mango/internal/testmeta"
 )
 
-type fakeClient struct {
-	resp string
-	err  error
-}
-
-func (f fakeClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
-	return f.resp, f.err
+func fakeClient(resp string) *llmfakes.FakeClient {
+	c := &llmfakes.FakeClient{}
+	c.ChatCompletionReturns(llm.Response{Text: resp}, nil)
+	return c
 }
 
 var _ = Describe("Predictor", func() {
 	It("parses predicted tests", func() {
-		p := New(fakeClient{resp: `["A"]`})
+		p := New(fakeClient(`["A"]`))
 		names, err := p.Predict(context.Background(), "future", []testmeta.Metadata{{Name: "A"}, {Name: "B"}})
 		Expect(err).NotTo(HaveOccurred())
 		Expect(names).To(ConsistOf("A"))
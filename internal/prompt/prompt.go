@@ -0,0 +1,153 @@
+// Package prompt renders the prompts mango sends to LLMs from text/template
+// templates. Defaults are embedded in the binary and can be overridden per
+// repository by files in Dir named after the template, e.g.
+// .mango/prompts/select_tests.tmpl. An override can include the default it
+// replaces with {{template "default" .}} and add domain hints around it.
+// {{template "preamble"}} explains the <data> sections to the model.
+package prompt
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/example/mango/internal/diff"
+	"github.com/example/mango/internal/testmeta"
+)
+
+// Template names.
+const (
+	SelectTests    = "select_tests"
+	SelectPackages = "select_packages"
+	Generate       = "generate"
+	Predict        = "predict"
+	Advise         = "advise"
+	Query          = "query"
+)
+
+// Dir holds repository overrides of the embedded templates.
+var Dir = ".mango/prompts"
+
+//go:embed templates/*.tmpl
+var defaults embed.FS
+
+// SelectionData is the data of the select_tests template.
+type SelectionData struct {
+	Changes []diff.Change
+	// Tests are the candidates; {{.ID}} renders the ID answers must use.
+	Tests []testmeta.Metadata
+}
+
+// PackageSelectionData is the data of the select_packages template.
+type PackageSelectionData struct {
+	Changes  []diff.Change
+	Packages []Package
+}
+
+// Package summarises a package for the select_packages template.
+type Package struct {
+	ImportPath string
+	Tests      int
+	// Exported lists exported symbols; More counts those left out.
+	Exported []string
+	More     int
+}
+
+// GenerationData is the data of the generate template.
+type GenerationData struct {
+	Changes []diff.Change
+	Tests   []testmeta.Metadata
+}
+
+// PredictionData is the data of the predict template.
+type PredictionData struct {
+	// Plan describes the upcoming change.
+	Plan  string
+	Tests []testmeta.Metadata
+}
+
+// AdviceData is the data of the advise template.
+type AdviceData struct {
+	VetOutput  string
+	TestOutput string
+}
+
+// QueryData is the data of the query template.
+type QueryData struct {
+	Question string
+	Tests    []testmeta.Metadata
+}
+
+var funcs = template.FuncMap{
+	"join":  strings.Join,
+	"field": Field,
+	"data":  data,
+}
+
+// dataTag matches the delimiters of untrusted sections.
+var dataTag = regexp.MustCompile(`(?i)</?\s*data\s*>`)
+
+// data neutralises delimiters inside untrusted text so it cannot close its
+// data section early.
+func data(s string) string {
+	return dataTag.ReplaceAllString(s, "[data]")
+}
+
+// Field renders an untrusted single-line value such as a test name. Line
+// breaks are flattened so the value cannot start list items of its own.
+// Answers quote values as rendered, so callers match them against Field of
+// the original.
+func Field(s string) string {
+	return data(strings.Join(strings.Fields(s), " "))
+}
+
+// Render executes the template called name with data. The override in Dir
+// is used when present, otherwise the embedded default.
+//
+// Templates wrap repository content, which is untrusted, in <data> sections
+// explained by the shared "preamble" template. The field and data functions
+// keep such content from breaking out of its section.
+func Render(name string, data interface{}) (string, error) {
+	t, err := load(name)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("prompt %s: %w", name, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func load(name string) (*template.Template, error) {
+	def, err := defaults.ReadFile("templates/" + name + ".tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("unknown prompt %q", name)
+	}
+	preamble, _ := defaults.ReadFile("templates/preamble.tmpl")
+	t, err := template.New("preamble").Funcs(funcs).Parse(string(preamble))
+	if err == nil {
+		t, err = t.New("default").Parse(string(def))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("prompt %s: %w", name, err)
+	}
+	path := filepath.Join(Dir, name+".tmpl")
+	override, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t, err = t.New(name).Parse(string(override)); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,99 @@
+// Package quarantine keeps the list of tests whose failures do not fail a
+// run, typically because they are flaky.
+package quarantine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is the quarantine file, relative to the repository. It is
+// meant to be committed so the whole team shares it.
+const DefaultPath = ".mango/quarantine.yaml"
+
+// Test is a quarantined test.
+type Test struct {
+	ID     string    `yaml:"id"`
+	Reason string    `yaml:"reason,omitempty"`
+	Added  time.Time `yaml:"added"`
+}
+
+// List is the content of a quarantine file.
+type List struct {
+	Tests []Test `yaml:"tests"`
+}
+
+// Load reads the quarantine file at path. A missing file is an empty list.
+func Load(path string) (*List, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &List{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l List
+	if err := yaml.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &l, nil
+}
+
+// Save writes the list to path, sorted by test ID.
+func (l *List) Save(path string) error {
+	sort.Slice(l.Tests, func(i, j int) bool { return l.Tests[i].ID < l.Tests[j].ID })
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Contains reports whether the test with this ID is quarantined. A nil list
+// quarantines nothing.
+func (l *List) Contains(id string) bool {
+	if l == nil {
+		return false
+	}
+	for _, e := range l.Tests {
+		if e.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Add quarantines id and reports whether it was new. The reason of an
+// existing entry is replaced when a new one is given.
+func (l *List) Add(id, reason string, now time.Time) bool {
+	for i, e := range l.Tests {
+		if e.ID == id {
+			if reason != "" {
+				l.Tests[i].Reason = reason
+			}
+			return false
+		}
+	}
+	l.Tests = append(l.Tests, Test{ID: id, Reason: reason, Added: now.UTC().Truncate(time.Second)})
+	return true
+}
+
+// Remove releases id from quarantine and reports whether it was there.
+func (l *List) Remove(id string) bool {
+	for i, e := range l.Tests {
+		if e.ID == id {
+			l.Tests = append(l.Tests[:i], l.Tests[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
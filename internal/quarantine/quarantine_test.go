@@ -0,0 +1,68 @@
+package quarantine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("List", func() {
+	var path string
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), ".mango", "quarantine.yaml")
+	})
+
+	It("starts empty without a file", func() {
+		q, err := Load(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(q.Tests).To(BeEmpty())
+		Expect(q.Contains("a:TestA")).To(BeFalse())
+	})
+
+	It("adds, removes and saves tests sorted by ID", func() {
+		q := &List{}
+		Expect(q.Add("b:TestB", "flaky", now)).To(BeTrue())
+		Expect(q.Add("a:TestA", "", now)).To(BeTrue())
+		Expect(q.Add("b:TestB", "timeouts on CI", now)).To(BeFalse())
+		Expect(q.Save(path)).To(Succeed())
+
+		data, _ := os.ReadFile(path)
+		Expect(string(data)).To(Equal(`tests:
+    - id: a:TestA
+      added: 2024-05-01T12:00:00Z
+    - id: b:TestB
+      reason: timeouts on CI
+      added: 2024-05-01T12:00:00Z
+`))
+
+		loaded, err := Load(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded.Contains("b:TestB")).To(BeTrue())
+		Expect(loaded.Remove("b:TestB")).To(BeTrue())
+		Expect(loaded.Remove("b:TestB")).To(BeFalse())
+		Expect(loaded.Contains("b:TestB")).To(BeFalse())
+	})
+
+	It("quarantines nothing when nil", func() {
+		var q *List
+		Expect(q.Contains("a:TestA")).To(BeFalse())
+	})
+
+	It("reports malformed files", func() {
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte("tests: {"), 0o644)
+		_, err := Load(path)
+		Expect(err).To(MatchError(ContainSubstring("quarantine.yaml")))
+	})
+})
+
+func TestQuarantine(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Quarantine Suite")
+}
@@ -0,0 +1,247 @@
+// Package redact removes secrets and denied paths from text before it is
+// sent to an LLM.
+package redact
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DefaultMinEntropy is the Shannon entropy, in bits per character, above
+// which long tokens are treated as secrets.
+const DefaultMinEntropy = 4.0
+
+// minSecretLen is the shortest token checked for entropy.
+const minSecretLen = 20
+
+// builtin masks well-known credential formats and email addresses. The
+// label ends up in the replacement so readers can tell what was removed.
+var builtin = []struct {
+	label string
+	re    *regexp.Regexp
+}{
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{"api-key", regexp.MustCompile(`\bsk-(?:ant-|proj-)?[A-Za-z0-9_-]{20,}`)},
+	{"api-key", regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`)},
+	{"api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+	{"api-key", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}`)},
+	{"api-key", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"email", regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)},
+}
+
+// assignment matches values assigned to secret-looking names, e.g.
+// password: hunter2 or API_KEY="abc".
+var assignment = regexp.MustCompile(`(?i)\b([A-Za-z0-9_]*(?:api[_-]?key|secret|token|password|passwd)[A-Za-z0-9_]*)(\s*[:=]\s*)("[^"]*"|'[^']*'|\S+)`)
+
+// maskAssignments masks the values matched by assignment, except in test IDs
+// such as auth/token:TestLogin, where the name is a directory.
+func maskAssignments(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range assignment.FindAllStringSubmatchIndex(text, -1) {
+		if isTestID(text, m[2], text[m[4]:m[5]]) {
+			continue
+		}
+		b.WriteString(text[last:m[6]])
+		b.WriteString(mask("secret"))
+		last = m[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// isTestID reports whether the name starting at text[start] and followed by
+// sep is the directory of a dir:Name test ID: a bare colon after a path
+// segment or at the start of a list item.
+func isTestID(text string, start int, sep string) bool {
+	if sep != ":" {
+		return false
+	}
+	before := text[:start]
+	line := before[strings.LastIndex(before, "\n")+1:]
+	return strings.HasSuffix(before, "/") || strings.TrimLeft(line, " \t") == "- "
+}
+
+var token = regexp.MustCompile(`[A-Za-z0-9+/=_-]{20,}`)
+
+// Redactor masks secrets and drops lines that mention denied paths.
+type Redactor struct {
+	// Patterns are additional expressions whose matches are masked.
+	Patterns []*regexp.Regexp
+	// DenyPaths are globs such as internal/secrets/**. Lines mentioning a
+	// matching file, directory, test ID or import path are dropped.
+	DenyPaths []string
+	// MinEntropy masks tokens of at least 20 characters mixing letters and
+	// digits whose entropy reaches it. Path segments and camel-case
+	// identifiers are exempt. Zero disables the check.
+	MinEntropy float64
+}
+
+// New returns a Redactor with the built-in rules plus the given patterns
+// and deny-list.
+func New(patterns, denyPaths []string, minEntropy float64) (*Redactor, error) {
+	r := &Redactor{DenyPaths: denyPaths, MinEntropy: minEntropy}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("redact pattern %q: %w", p, err)
+		}
+		r.Patterns = append(r.Patterns, re)
+	}
+	for _, g := range denyPaths {
+		if _, err := path.Match(strings.ReplaceAll(g, "**", "*"), ""); err != nil {
+			return nil, fmt.Errorf("deny path %q: %w", g, err)
+		}
+	}
+	return r, nil
+}
+
+// Redact returns text with denied lines dropped and secrets masked.
+func (r *Redactor) Redact(text string) string {
+	if len(r.DenyPaths) > 0 {
+		lines := strings.SplitAfter(text, "\n")
+		kept := lines[:0]
+		for _, l := range lines {
+			if !r.denied(l) {
+				kept = append(kept, l)
+			}
+		}
+		text = strings.Join(kept, "")
+	}
+	for _, b := range builtin {
+		text = b.re.ReplaceAllString(text, mask(b.label))
+	}
+	text = maskAssignments(text)
+	for _, re := range r.Patterns {
+		text = re.ReplaceAllString(text, mask("pattern"))
+	}
+	if r.MinEntropy > 0 {
+		text = token.ReplaceAllStringFunc(text, func(t string) string {
+			// Paths and test IDs are scored segment by segment, so only a
+			// random-looking segment masks them.
+			for _, part := range strings.FieldsFunc(t, isSeparator) {
+				if isSecret(part, r.MinEntropy) {
+					return mask("secret")
+				}
+			}
+			return t
+		})
+	}
+	return text
+}
+
+func mask(label string) string {
+	return "[REDACTED:" + label + "]"
+}
+
+// denied reports whether line mentions a path on the deny-list.
+func (r *Redactor) denied(line string) bool {
+	for _, field := range strings.FieldsFunc(line, func(c rune) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == ',' || c == '"' || c == '\'' || c == '(' || c == ')'
+	}) {
+		// Test IDs are dir:Name; "file.go:12:" style positions end in
+		// numbers. Either way the path is before the first colon.
+		p, _, _ := strings.Cut(strings.TrimPrefix(field, "./"), ":")
+		if p == "" {
+			continue
+		}
+		for _, g := range r.DenyPaths {
+			if matchSuffix(g, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchSuffix reports whether glob matches p or any trailing run of its
+// segments, so import paths match repository-relative globs.
+func matchSuffix(glob, p string) bool {
+	segs := strings.Split(p, "/")
+	for i := range segs {
+		if Match(glob, strings.Join(segs[i:], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether p matches glob. A ** segment matches any number of
+// path segments, including none; other segments follow path.Match.
+func Match(glob, p string) bool {
+	return matchSegments(strings.Split(glob, "/"), strings.Split(p, "/"))
+}
+
+func matchSegments(glob, p []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := 0; i <= len(p); i++ {
+				if matchSegments(glob[1:], p[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(p) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], p[0]); !ok {
+			return false
+		}
+		glob, p = glob[1:], p[1:]
+	}
+	return len(p) == 0
+}
+
+// isSecret reports whether t looks like a generated credential: long, mixing
+// letters and digits, close to random and not an identifier.
+func isSecret(t string, minEntropy float64) bool {
+	if len(t) < minSecretLen || !strings.ContainsAny(t, "0123456789") || strings.IndexFunc(t, isLetter) < 0 {
+		return false
+	}
+	return entropy(t) >= minEntropy && !isIdentifier(t)
+}
+
+// word matches the words of a camel-case identifier, e.g. Test, UTF and
+// Decoding in TestUTF8Decoding.
+var word = regexp.MustCompile(`[A-Z]*[a-z]+|[A-Z]+`)
+
+// isIdentifier reports whether t reads as words rather than random
+// characters: at least three quarters of it are words of three letters or
+// more. Generated tokens break into runs of one or two letters.
+func isIdentifier(t string) bool {
+	n := 0
+	for _, w := range word.FindAllString(t, -1) {
+		if len(w) >= 3 {
+			n += len(w)
+		}
+	}
+	return n*4 >= len(t)*3
+}
+
+// isSeparator splits paths, test IDs and snake_case names into segments.
+func isSeparator(c rune) bool {
+	return c == '/' || c == '_' || c == '.' || c == ':'
+}
+
+func isLetter(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, c := range s {
+		counts[c]++
+	}
+	var h float64
+	n := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
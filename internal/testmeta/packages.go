@@ -0,0 +1,146 @@
+package testmeta
+
+import (
+	"bufio"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Package summarises a Go package that contains tests.
+type Package struct {
+	Dir        string
+	ImportPath string
+	Exported   []string
+	Tests      int
+}
+
+// Packages groups tests by directory and summarises each package with its
+// import path and exported symbols.
+func Packages(tests []Metadata) ([]Package, error) {
+	counts := map[string]int{}
+	for _, t := range tests {
+		counts[filepath.Dir(t.File)]++
+	}
+	module := ModulePath(".")
+
+	var pkgs []Package
+	for dir, n := range counts {
+		exported, err := exportedSymbols(dir)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, Package{
+			Dir:        filepath.ToSlash(dir),
+			ImportPath: importPath(module, dir),
+			Exported:   exported,
+			Tests:      n,
+		})
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Dir < pkgs[j].Dir })
+	return pkgs, nil
+}
+
+// ModulePath returns the module path declared in dir/go.mod, or "" if there is none.
+func ModulePath(dir string) string {
+	f, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "module ") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "module ")), `"`)
+		}
+	}
+	return ""
+}
+
+func importPath(module, dir string) string {
+	dir = filepath.ToSlash(dir)
+	if module == "" {
+		return dir
+	}
+	if dir == "." {
+		return module
+	}
+	return path.Join(module, dir)
+}
+
+// exportedSymbols lists the exported top-level identifiers declared in the
+// non-test Go files of dir.
+func exportedSymbols(dir string) ([]string, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, p := range pkgs {
+		for _, f := range p.Files {
+			for _, decl := range f.Decls {
+				for _, name := range declNames(decl) {
+					if ast.IsExported(name) {
+						seen[name] = true
+					}
+				}
+			}
+		}
+	}
+	var names []string
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func declNames(decl ast.Decl) []string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			if recv := receiverName(d.Recv.List[0].Type); recv != "" && ast.IsExported(recv) && ast.IsExported(d.Name.Name) {
+				return []string{recv + "." + d.Name.Name}
+			}
+			return nil
+		}
+		return []string{d.Name.Name}
+	case *ast.GenDecl:
+		var names []string
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, n := range s.Names {
+					names = append(names, n.Name)
+				}
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+func receiverName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(t.X)
+	case *ast.IndexExpr:
+		return receiverName(t.X)
+	case *ast.IndexListExpr:
+		return receiverName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
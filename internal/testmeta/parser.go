@@ -18,6 +18,12 @@ type Metadata struct {
 	Ginkgo  bool
 }
 
+// ID returns a stable identifier for the test made of its package directory
+// and name, e.g. "internal/diff:TestDiff".
+func (m Metadata) ID() string {
+	return filepath.ToSlash(filepath.Dir(m.File)) + ":" + m.Name
+}
+
 // Extract scans the repository for tests and returns their metadata.
 func Extract() ([]Metadata, error) {
 	var meta []Metadata
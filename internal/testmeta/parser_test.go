@@ -34,6 +34,37 @@ var _ = Describe("Bar", func(){It("works", func(){})})
 	})
 })
 
+var _ = Describe("Packages", func() {
+	It("summarises packages that contain tests", func() {
+		dir := GinkgoT().TempDir()
+		os.MkdirAll(filepath.Join(dir, "calc"), 0o755)
+		os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n\ngo 1.23.0\n"), 0o644)
+		os.WriteFile(filepath.Join(dir, "calc", "calc.go"), []byte(`package calc
+type Calc struct{}
+func (Calc) Add(a, b int) int { return a + b }
+func (Calc) reset() {}
+func Sub(a, b int) int { return a - b }
+func helper() {}
+const Pi = 3
+`), 0o644)
+		old, _ := os.Getwd()
+		os.Chdir(dir)
+		defer os.Chdir(old)
+
+		pkgs, err := Packages([]Metadata{
+			{Name: "TestAdd", File: "calc/calc_test.go", Package: "calc"},
+			{Name: "TestSub", File: "calc/calc_test.go", Package: "calc"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pkgs).To(Equal([]Package{{
+			Dir:        "calc",
+			ImportPath: "example.com/app/calc",
+			Exported:   []string{"Calc", "Calc.Add", "Pi", "Sub"},
+			Tests:      2,
+		}}))
+	})
+})
+
 func TestParser(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Parser Suite")